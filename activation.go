@@ -0,0 +1,340 @@
+package ipkg
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+// Activation of package is atomic: links created by activate instructions point to
+// <root>/current/<name>/<path>, where current/<name> is a symbolic link to active installation folder (name-$version).
+// Switching versions replaces current/<name> with a single rename, so links of package always lead to one version.
+//
+// Packages installed by older versions of ipkg have links pointing to installation folder directly,
+// they are active unless .ira/deactivated flag exists.
+
+// activationLink is a symbolic link created when package is activated
+type activationLink struct {
+	Target string `json:"target"` // path relative to installation folder, slash separated
+	Link   string `json:"link"`   // absolute path of link
+}
+
+// currentLink returns path of link to active version of package name
+func (r *Root) currentLink(name string) string {
+	return filepath.Join(r.path, "current", name)
+}
+
+// linkTarget returns absolute path which activation link of package name points to
+func (r *Root) linkTarget(name, target string) (string, error) {
+	abs, err := filepath.Abs(r.currentLink(name))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(abs, filepath.FromSlash(target)), nil
+}
+
+// activeVersion returns version which current/<name> points to. If there is no such link, returns false
+func (r *Root) activeVersion(name string) (string, bool) {
+	target, err := os.Readlink(r.currentLink(name))
+	if err != nil {
+		return "", false
+	}
+	_, version, err := ParseID(filepath.Base(target))
+	if err != nil {
+		return "", false
+	}
+	return version, true
+}
+
+// switchCurrent atomically points current/<name> to name-$version
+func (r *Root) switchCurrent(name, version string) error {
+	if err := os.MkdirAll(filepath.Join(r.path, "current"), os.ModePerm); err != nil {
+		return fmt.Errorf("creating folder of active packages: %w", err)
+	}
+	tmp := r.currentLink(name) + ".tmp"
+	os.Remove(tmp) // left by interrupted activation
+	if err := os.Symlink(filepath.Join("..", PackageID(name, version)), tmp); err != nil {
+		return fmt.Errorf("creating link to active version: %w", err)
+	}
+	if err := os.Rename(tmp, r.currentLink(name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("switching active version: %w", err)
+	}
+	return nil
+}
+
+// extractActivation removes activate instructions from install section of IScript and returns them,
+// so activation links are created by Root instead of IScript
+func extractActivation(script []byte) ([]byte, []activationLink, error) {
+	var s scanner.Scanner
+	s.Init(bytes.NewReader(script))
+	s.Mode = scanner.GoTokens
+	s.Error = func(*scanner.Scanner, string) {} // syntax errors are reported by IScript
+	result := append([]byte(nil), script...)
+	var links []activationLink
+	section := ""
+	for token := s.Scan(); token != scanner.EOF; token = s.Scan() {
+		if token != scanner.Ident {
+			continue
+		}
+		switch s.TokenText() {
+		case "flag":
+			s.Scan()
+			section = s.TokenText()
+			continue
+		case "activate":
+		default:
+			continue
+		}
+		if section != "install" {
+			continue
+		}
+		start := s.Position.Offset
+		var args [2]string
+		valid := true
+		for i := range args {
+			if s.Scan() != scanner.String {
+				valid = false
+				break
+			}
+			args[i], _ = strconv.Unquote(s.TokenText())
+		}
+		if !valid {
+			continue // IScript reports invalid instruction
+		}
+		end := s.Position.Offset + len(s.TokenText())
+		target, ok := validTarget(args[0])
+		if !ok {
+			return nil, nil, fmt.Errorf("incorrect path %q in activate instruction", args[0])
+		}
+		if !filepath.IsAbs(args[1]) {
+			return nil, nil, fmt.Errorf("%q must be absolute path", args[1])
+		}
+		links = append(links, activationLink{Target: target, Link: args[1]})
+		// Instruction is replaced with spaces, so positions in IScript errors stay the same
+		for i := start; i < end; i++ {
+			if result[i] != '\n' {
+				result[i] = ' '
+			}
+		}
+	}
+	return result, links, nil
+}
+
+// validTarget cleans path inside package and checks that it doesn't leave installation folder
+func validTarget(path string) (string, bool) {
+	path = strings.TrimPrefix(strings.ReplaceAll(path, "\\", "/"), "/")
+	depth := 0
+	for _, dir := range strings.Split(path, "/") {
+		if dir == ".." {
+			depth--
+		} else if dir != "." && dir != "" {
+			depth++
+		}
+		if depth < 0 {
+			return "", false
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(path)), true
+}
+
+// activationLog returns path to activation log of package installed in path.
+// Every line of log is activationLink in JSON. Older ipkg and IScript wrote lines "target link",
+// where target is absolute path inside installation folder, such logs are still read
+func activationLog(path string) string {
+	return filepath.Join(path, ".ira", "activate.log")
+}
+
+// writeActivationLog saves links of package installed in installDir
+func writeActivationLog(installDir string, links []activationLink) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, link := range links {
+		if err := encoder.Encode(link); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(activationLog(installDir), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing activation log: %w", err)
+	}
+	return nil
+}
+
+// readActivationLog returns links of package installed in installDir.
+// legacy is true if log was written in old format
+func readActivationLog(installDir string) (links []activationLink, legacy bool, err error) {
+	file, err := os.Open(activationLog(installDir))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("opening activation log: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		var link activationLink
+		if strings.HasPrefix(line, "{") {
+			if err = json.Unmarshal([]byte(line), &link); err != nil {
+				return nil, false, fmt.Errorf("broken line in activation log: %q", line)
+			}
+		} else {
+			legacy = true
+			link, err = parseLegacyLink(installDir, line)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		if _, ok := validTarget(link.Target); !ok || !filepath.IsAbs(link.Link) {
+			return nil, false, fmt.Errorf("link %s points outside of package", link.Link)
+		}
+		links = append(links, link)
+	}
+	if scanner.Err() != nil {
+		return nil, false, fmt.Errorf("scanning activation log: %w", scanner.Err())
+	}
+	return links, legacy, nil
+}
+
+// parseLegacyLink parses line "target link" of old activation log. Both paths can contain spaces,
+// so line is split at the first space where target is inside installDir and link is absolute
+func parseLegacyLink(installDir, line string) (activationLink, error) {
+	prefixes := []string{installDir}
+	if abs, err := filepath.Abs(installDir); err == nil {
+		prefixes = append(prefixes, abs)
+	}
+	for i := strings.IndexByte(line, ' '); i != -1; {
+		target, link := line[:i], line[i+1:]
+		if filepath.IsAbs(link) {
+			for _, prefix := range prefixes {
+				rel, err := filepath.Rel(prefix, target)
+				if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+					return activationLink{Target: filepath.ToSlash(rel), Link: link}, nil
+				}
+			}
+		}
+		next := strings.IndexByte(line[i+1:], ' ')
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return activationLink{}, fmt.Errorf("broken line in activation log: %q", line)
+}
+
+// legacyTarget returns absolute path which link of package installed in installDir pointed to before
+func legacyTarget(installDir string, link activationLink) string {
+	return filepath.Join(installDir, filepath.FromSlash(link.Target))
+}
+
+// LinkConflictError is returned when activation link can't be created, because path is taken by another package or file
+type LinkConflictError struct {
+	Path    string
+	Name    string // package owning path, empty if path isn't owned by any package
+	Version string
+}
+
+func (e *LinkConflictError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("can't create link %s: file already exists and doesn't belong to any package", e.Path)
+	}
+	return fmt.Sprintf("can't create link %s: path belongs to package %s", e.Path, PackageID(e.Name, e.Version))
+}
+
+// linkOwner returns package owning activation link in path. If nobody owns it, returns empty strings
+func (r *Root) linkOwner(path string) (name, version string, err error) {
+	err = r.db.QueryRow("SELECT name, version FROM links WHERE path = ?", path).Scan(&name, &version)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", fmt.Errorf("getting owner of %s: %v", path, err)
+	}
+	return name, version, nil
+}
+
+// checkLinks checks that links of package name can be created, so activation doesn't stop halfway
+func (r *Root) checkLinks(name string, links []activationLink) error {
+	for _, link := range links {
+		owner, version, err := r.linkOwner(link.Link)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != name {
+			return &LinkConflictError{Path: link.Link, Name: owner, Version: version}
+		}
+		existing, err := os.Readlink(link.Link)
+		if err == nil {
+			target, err := r.linkTarget(name, link.Target)
+			if err != nil {
+				return err
+			}
+			if owner != "" || existing == target || r.isLegacyTarget(name, existing) {
+				continue
+			}
+		}
+		if _, err = os.Lstat(link.Link); err == nil {
+			return &LinkConflictError{Path: link.Link}
+		}
+	}
+	return nil
+}
+
+// migrateLinks creates table of link owners and fills it with links of active packages
+func migrateLinks(r *Root, tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE links (
+		path TEXT NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		version TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT name, version FROM packages")
+	if err != nil {
+		return err
+	}
+	var pkgs []PkgConfig
+	for rows.Next() {
+		var pkg PkgConfig
+		if err = rows.Scan(&pkg.Name, &pkg.Version); err != nil {
+			rows.Close()
+			return err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		installDir := filepath.Join(r.path, PackageID(pkg.Name, pkg.Version))
+		links, legacy, err := readActivationLog(installDir)
+		if err != nil {
+			return fmt.Errorf("reading activation log of %s: %w", PackageID(pkg.Name, pkg.Version), err)
+		}
+		if legacy {
+			if err = writeActivationLog(installDir, links); err != nil {
+				return err
+			}
+		}
+		if !r.isActiveInstallation(pkg.Name, pkg.Version) {
+			continue
+		}
+		for _, link := range links {
+			if _, err = tx.Exec("INSERT OR IGNORE INTO links VALUES (?, ?, ?)", link.Link, pkg.Name, pkg.Version); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
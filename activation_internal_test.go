@@ -0,0 +1,183 @@
+package ipkg
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExtractActivation(t *testing.T) {
+	script := `flag install
+install 777 "/bin/tool" "/tool"
+activate "/bin/tool" "/usr/bin/tool"
+print "activate"
+flag remove
+remove "/bin/tool"`
+	stripped, links, err := extractActivation([]byte(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0] != (activationLink{Target: "bin/tool", Link: "/usr/bin/tool"}) {
+		t.Errorf("wrong links: %+v", links)
+	}
+	if strings.Contains(string(stripped), "/usr/bin/tool") || !strings.Contains(string(stripped), `print "activate"`) {
+		t.Errorf("wrong stripped script:\n%s", stripped)
+	}
+	if strings.Count(string(stripped), "\n") != strings.Count(script, "\n") {
+		t.Error("lines of script were changed")
+	}
+	if _, _, err = extractActivation([]byte(`flag install
+activate "../../etc/passwd" "/usr/bin/tool"`)); err == nil {
+		t.Error("link outside package was accepted")
+	}
+}
+
+func TestActivateLegacyPackage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	path := t.TempDir()
+	root, err := CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	links := t.TempDir()
+	// Versions installed by older ipkg: 1.0 is active and its link points to installation folder
+	for _, version := range []string{"1.0", "2.0"} {
+		installDir := filepath.Join(path, PackageID("tool", version))
+		if err = os.MkdirAll(filepath.Join(installDir, ".ira"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(installDir, "tool"), []byte(version), 0644); err != nil {
+			t.Fatal(err)
+		}
+		log := filepath.Join(installDir, "tool") + " " + filepath.Join(links, "tool") + "\n"
+		if err = os.WriteFile(activationLog(installDir), []byte(log), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = root.db.Exec("INSERT INTO packages (name, version, dependencies, by_user, active) VALUES ('tool', ?, '', 1, ?)", version, version == "1.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Symlink(filepath.Join(path, PackageID("tool", "1.0"), "tool"), filepath.Join(links, "tool")); err != nil {
+		t.Fatal(err)
+	}
+	if !root.IsActive("tool", "1.0") || root.IsActive("tool", "2.0") {
+		t.Fatal("active version of legacy package is wrong")
+	}
+
+	if err = root.ActivatePackage("tool", "2.0"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(links, "tool")); err != nil || string(content) != "2.0" {
+		t.Errorf("link leads to %q (%v), expected 2.0", content, err)
+	}
+	if root.IsActive("tool", "1.0") || !root.IsActive("tool", "2.0") {
+		t.Error("active version wasn't switched")
+	}
+	if version, ok := root.activeVersion("tool"); !ok || version != "2.0" {
+		t.Errorf("current link points to %q", version)
+	}
+}
+
+func TestMigrateActivationLog(t *testing.T) {
+	path := t.TempDir()
+	// Active package installed by older ipkg with spaces in paths
+	installDir := filepath.Join(path, PackageID("tool", "1.0"))
+	if err := os.MkdirAll(filepath.Join(installDir, ".ira"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(t.TempDir(), "my links", "tool link")
+	log := filepath.Join(installDir, "bin", "my tool") + " " + link + "\n"
+	if err := os.WriteFile(activationLog(installDir), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Database of version 1, before link owners were stored
+	db, err := sql.Open("sqlite3", filepath.Join(path, "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE packages (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		version TEXT NOT NULL,
+		dependencies TEXT NOT NULL,
+		by_user INTEGER NOT NULL DEFAULT (0),
+		used_by INTEGER NOT NULL DEFAULT (0),
+		checksum TEXT NOT NULL DEFAULT ('')
+	);
+	INSERT INTO packages VALUES (NULL, 'tool', '1.0', '', 1, 0, '');
+	PRAGMA user_version = 1;`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := OpenRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	links, legacy, err := readActivationLog(installDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy {
+		t.Error("activation log wasn't rewritten")
+	}
+	if len(links) != 1 || links[0] != (activationLink{Target: "bin/my tool", Link: link}) {
+		t.Errorf("wrong links: %+v", links)
+	}
+	if name, version, err := root.linkOwner(link); err != nil || name != "tool" || version != "1.0" {
+		t.Errorf("wrong owner of link: %s-$%s (%v)", name, version, err)
+	}
+	if !root.IsActive("tool", "1.0") {
+		t.Error("active package became inactive after upgrade")
+	}
+}
+
+func TestMigrateActiveChoosesNewest(t *testing.T) {
+	path := t.TempDir()
+	// Both versions were active by flag files of older ipkg
+	for _, version := range []string{"1.0", "2.0"} {
+		if err := os.MkdirAll(filepath.Join(path, PackageID("tool", version), ".ira"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(path, "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE packages (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		version TEXT NOT NULL,
+		dependencies TEXT NOT NULL,
+		by_user INTEGER NOT NULL DEFAULT (0),
+		used_by INTEGER NOT NULL DEFAULT (0),
+		checksum TEXT NOT NULL DEFAULT ('')
+	);
+	INSERT INTO packages VALUES (NULL, 'tool', '2.0', '', 1, 0, '');
+	INSERT INTO packages VALUES (NULL, 'tool', '1.0', '', 1, 0, '');
+	PRAGMA user_version = 1;`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := OpenRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	for _, version := range []string{"1.0", "2.0"} {
+		if root.IsActive("tool", version) != (version == "2.0") {
+			t.Errorf("wrong activation state of tool-$%s after upgrade", version)
+		}
+	}
+}
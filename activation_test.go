@@ -0,0 +1,238 @@
+package ipkg_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+// writeActivatedPackage creates package with activation links of files to linkDir
+func writeActivatedPackage(t *testing.T, dir, linkDir, version string, files ...string) string {
+	t.Helper()
+	path := writePackage(t, dir, "tool", version, nil)
+	script := "flag install\n"
+	for _, file := range files {
+		script += "install 777 \"/" + file + "\" \"/" + file + "\"\n"
+		script += "activate \"/" + file + "\" \"" + filepath.Join(linkDir, file) + "\"\n"
+		if err := os.WriteFile(filepath.Join(path, file), []byte(version), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(path, ".ira", "iscript"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAtomicActivation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	if err = root.InstallPackage(writeActivatedPackage(t, dir, links, "1.0", "tool", "old"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage(writeActivatedPackage(t, dir, links, "2.0", "tool", "new"), false); err != nil {
+		t.Fatal(err)
+	}
+	// check verifies that active version is active and files lead to it. Empty active means no active versions
+	check := func(active string, files map[string]bool) {
+		t.Helper()
+		if active != "" && !root.IsActive("tool", active) {
+			t.Errorf("tool-$%s isn't active", active)
+		}
+		for file, exists := range files {
+			content, err := os.ReadFile(filepath.Join(links, file))
+			if !exists {
+				if _, err := os.Lstat(filepath.Join(links, file)); err == nil {
+					t.Errorf("link %s of inactive version exists", file)
+				}
+			} else if err != nil {
+				t.Errorf("link %s: %v", file, err)
+			} else if string(content) != active {
+				t.Errorf("link %s leads to version %s, expected %s", file, content, active)
+			}
+		}
+	}
+	check("2.0", map[string]bool{"tool": true, "new": true, "old": false})
+	if root.IsActive("tool", "1.0") {
+		t.Error("two versions are active")
+	}
+	if err = root.ActivatePackage("tool", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+	check("1.0", map[string]bool{"tool": true, "new": false, "old": true})
+	if info, err := root.Info("tool", "1.0"); err != nil || info.ActivatedAt.IsZero() {
+		t.Errorf("activation time wasn't saved: %v", err)
+	}
+	// Removing active version leaves no active versions
+	if err = root.RemovePackage("tool", "1.0", false); err != nil {
+		t.Fatal(err)
+	}
+	if root.IsActive("tool", "2.0") {
+		t.Error("version became active after removing active one")
+	}
+	check("", map[string]bool{"tool": false, "old": false})
+}
+
+func TestActivationConflicts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), filepath.Join(t.TempDir(), "dir with spaces")
+	if err = os.Mkdir(links, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage(writeActivatedPackage(t, dir, links, "1.0", "tool"), false); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(links, "tool")); err != nil || string(content) != "1.0" {
+		t.Fatalf("link in path with spaces leads to %q (%v)", content, err)
+	}
+
+	// Another package creating the same link
+	other := writeActivatedPackage(t, t.TempDir(), links, "1.0", "tool")
+	config := []byte(`{"name": "other", "version": "1.0", "supportLinux": true, "supportWindows": true}`)
+	if err = os.WriteFile(filepath.Join(other, ".ira", "config.json"), config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var conflictErr *ipkg.LinkConflictError
+	err = root.InstallPackage(other, false)
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected LinkConflictError, got %v", err)
+	}
+	if conflictErr.Name != "tool" || conflictErr.Version != "1.0" || conflictErr.Path != filepath.Join(links, "tool") {
+		t.Errorf("wrong conflict: %+v", conflictErr)
+	}
+	if _, err = root.FindPackage("other", "1.0"); err == nil {
+		t.Error("conflicting package was installed")
+	}
+
+	// File which doesn't belong to any package
+	if err = os.WriteFile(filepath.Join(links, "new"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = root.InstallPackage(writeActivatedPackage(t, dir, links, "2.0", "tool", "new"), false)
+	if !errors.As(err, &conflictErr) || conflictErr.Name != "" {
+		t.Errorf("expected LinkConflictError without owner, got %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(links, "tool")); err != nil || string(content) != "1.0" {
+		t.Errorf("failed installation changed active version: %q (%v)", content, err)
+	}
+}
+
+func TestDeactivationKeepsForeignFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	skipped := map[string]ipkg.Event{}
+	observer := ipkg.ObserverFunc(func(event ipkg.Event) {
+		if event.Type == ipkg.EventLinkSkipped {
+			skipped[filepath.Base(event.File)] = event
+		}
+	})
+	root, err := ipkg.CreateRoot(t.TempDir(), ipkg.WithLogger(logger), ipkg.WithObserver(observer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	if err = root.InstallPackage(writeActivatedPackage(t, dir, links, "1.0", "tool", "lib", "data"), false); err != nil {
+		t.Fatal(err)
+	}
+	// User replaced links with own file and link
+	if err = os.Remove(filepath.Join(links, "tool")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(links, "tool"), []byte("user"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(links, "lib")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(filepath.Join(links, "tool"), filepath.Join(links, "lib")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = root.RemovePackage("tool", "1.0", false); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(links, "tool")); err != nil || string(content) != "user" {
+		t.Errorf("user's file was changed: %q (%v)", content, err)
+	}
+	if _, err = os.Lstat(filepath.Join(links, "lib")); err != nil {
+		t.Errorf("user's link was removed: %v", err)
+	}
+	if _, err = os.Lstat(filepath.Join(links, "data")); err == nil {
+		t.Error("link of package wasn't removed")
+	}
+	if strings.Count(buf.String(), "link skipped") != 2 {
+		t.Errorf("skipped links weren't reported:\n%s", buf.String())
+	}
+	for _, file := range []string{"tool", "lib"} {
+		if event, ok := skipped[file]; !ok || event.Operation != ipkg.OpRemove || event.Name != "tool" || event.Detail == "" {
+			t.Errorf("skipped link %s wasn't reported to observer: %+v", file, event)
+		}
+	}
+	if len(skipped) != 2 {
+		t.Errorf("expected 2 skipped links, got %v", skipped)
+	}
+}
+
+func TestFailedActivationRemovesLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	if err = root.InstallPackage(writeActivatedPackage(t, dir, links, "1.0", "tool"), false); err != nil {
+		t.Fatal(err)
+	}
+	// Parent of the second link is a regular file, so the link can't be created after the first one
+	file := filepath.Join(t.TempDir(), "file")
+	if err = os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkg := writeActivatedPackage(t, dir, links, "2.0", "tool", "first")
+	script := "flag install\ninstall 777 \"/first\" \"/first\"\n" +
+		"activate \"/first\" \"" + filepath.Join(links, "first") + "\"\n" +
+		"activate \"/first\" \"" + filepath.Join(file, "second") + "\"\n"
+	if err = os.WriteFile(filepath.Join(pkg, ".ira", "iscript"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage(pkg, false); err == nil {
+		t.Fatal("package with broken link was activated")
+	}
+	if _, err = os.Lstat(filepath.Join(links, "first")); !os.IsNotExist(err) {
+		t.Errorf("link of failed activation is left: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(links, "tool")); err != nil || string(content) != "1.0" {
+		t.Errorf("failed activation changed active version: %q (%v)", content, err)
+	}
+	if !root.IsActive("tool", "1.0") {
+		t.Error("previous version isn't active after failed activation")
+	}
+}
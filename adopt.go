@@ -0,0 +1,152 @@
+package ipkg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	osextra "github.com/ira-package-manager/gobetter/os_extra"
+)
+
+// rootFolders are folders of package root used by ipkg itself, they can't be adopted
+var rootFolders = map[string]bool{"cache": true, "config": true, "current": true, "journal": true, "logs": true}
+
+// adoptedScript is IScript of adopted package: files are already installed and removed with installation folder
+const adoptedScript = "flag install\nflag remove\n"
+
+// Adopt registers directory dir placed directly in root, which was filled without ipkg, as installed package name-$version.
+// Directory is renamed to installation folder name-$version if it has another name. Missing metadata
+// (config, IScript and activation log) is created in .ira folder, no IScript is run.
+// Adopted package is installed by user and becomes active, if package has no active version yet
+func (r *Root) Adopt(ctx context.Context, dir, name, version string) error {
+	if name == "" || version == "" || strings.Contains(name, "-$") || strings.ContainsAny(name+version, `/\`) {
+		return fmt.Errorf("incorrect package name %q or version %q", name, version)
+	}
+	unlock, err := r.lockPackage(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err = r.FindPackage(name, version); err == nil {
+		return fmt.Errorf("package %s-$%s is %w", name, version, ErrAlreadyInstalled)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	// Checking directory
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	rootPath, err := filepath.Abs(r.path)
+	if err != nil {
+		return err
+	}
+	// Only folders placed directly in root can be adopted, folders of ipkg and installed packages are refused
+	if filepath.Dir(dir) != rootPath {
+		return fmt.Errorf("%s must be a directory directly inside package root %s", dir, rootPath)
+	}
+	base := filepath.Base(dir)
+	if rootFolders[base] || strings.HasPrefix(base, ".") {
+		return fmt.Errorf("%s is used by ipkg and can't be adopted", dir)
+	}
+	if ownerName, ownerVersion, err := ParseID(base); err == nil {
+		if _, err = r.FindPackage(ownerName, ownerVersion); err == nil {
+			return fmt.Errorf("%s is installation folder of package %s, it can't be adopted", dir, base)
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s isn't a directory", dir)
+	}
+	installDir := filepath.Join(rootPath, PackageID(name, version))
+	if dir != installDir {
+		if osextra.Exists(installDir) {
+			return fmt.Errorf("can't move %s: %s already exists", dir, installDir)
+		}
+		if err = os.Rename(dir, installDir); err != nil {
+			return fmt.Errorf("moving %s to installation folder: %w", dir, err)
+		}
+	}
+	logger := r.pkgLogger(name, version)
+	config, created, err := writeAdoptedMetadata(installDir, name, version)
+	if err == nil {
+		err = r.registerPackage(ctx, config, "", false)
+	}
+	if err != nil {
+		// Directory is returned to user as it was: only metadata created here is removed, files of user are kept
+		for i := len(created) - 1; i >= 0; i-- {
+			if removeErr := os.Remove(created[i]); removeErr != nil {
+				logger.Warn("metadata of adopted package isn't removed", "path", created[i], "error", removeErr)
+			}
+		}
+		if dir != installDir {
+			os.Rename(installDir, dir)
+		}
+		return err
+	}
+	if _, hasActive := r.activeVersion(name); !hasActive {
+		if err = r.activate(name, version); err != nil {
+			return fmt.Errorf("activating package: %w", err)
+		}
+	}
+	logger.Info("package adopted", "path", dir)
+	return nil
+}
+
+// writeAdoptedMetadata creates missing files in .ira folder of adopted package installed in installDir and returns its config.
+// Paths created by it are returned in order of creation, even if error occurs
+func writeAdoptedMetadata(installDir, name, version string) (*PkgConfig, []string, error) {
+	var created []string
+	metadata := filepath.Join(installDir, ".ira")
+	if !osextra.Exists(metadata) {
+		if err := os.Mkdir(metadata, os.ModePerm); err != nil {
+			return nil, created, fmt.Errorf("creating configuration folder: %w", err)
+		}
+		created = append(created, metadata)
+	}
+	configPath := filepath.Join(metadata, "config.json")
+	config, err := ParseConfig(configPath)
+	if os.IsNotExist(err) {
+		config = &PkgConfig{
+			Name:           name,
+			Version:        version,
+			Dependencies:   map[string]bool{},
+			SupportWindows: runtime.GOOS == "windows",
+			SupportLinux:   runtime.GOOS == "linux",
+		}
+		content, err := json.MarshalIndent(config, "", "    ")
+		if err != nil {
+			return nil, created, err
+		}
+		if err = os.WriteFile(configPath, content, 0644); err != nil {
+			return nil, created, fmt.Errorf("writing config: %w", err)
+		}
+		created = append(created, configPath)
+	} else if err != nil {
+		return nil, created, err
+	} else if config.Name != name || config.Version != version {
+		return nil, created, fmt.Errorf("config of %s describes package %s", installDir, PackageID(config.Name, config.Version))
+	}
+	scriptPath := filepath.Join(metadata, "iscript")
+	if !osextra.Exists(scriptPath) {
+		if err = os.WriteFile(scriptPath, []byte(adoptedScript), 0644); err != nil {
+			return nil, created, fmt.Errorf("writing IScript: %w", err)
+		}
+		created = append(created, scriptPath)
+	}
+	if !osextra.Exists(activationLog(installDir)) {
+		if err = writeActivationLog(installDir, nil); err != nil {
+			return nil, created, err
+		}
+		created = append(created, activationLog(installDir))
+	}
+	return config, created, nil
+}
@@ -0,0 +1,141 @@
+package ipkg_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestAdopt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	manual := filepath.Join(path, "manual")
+	if err = os.MkdirAll(filepath.Join(manual, "bin"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(manual, "bin", "tool"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.Adopt(context.Background(), t.TempDir(), "tool", "1.0"); err == nil {
+		t.Error("directory outside root was adopted")
+	}
+	if err = root.InstallPackage(writePackage(t, t.TempDir(), "lib", "1.0", nil), false); err != nil {
+		t.Fatal(err)
+	}
+	libDir := filepath.Join(path, ipkg.PackageID("lib", "1.0"))
+	for _, dir := range []string{libDir, filepath.Join(libDir, "bin"), filepath.Join(path, "current"), filepath.Join(manual, "bin")} {
+		if err = root.Adopt(context.Background(), dir, "stolen", "1"); err == nil {
+			t.Errorf("%s was adopted", dir)
+		}
+	}
+	if _, err = os.Stat(libDir); err != nil {
+		t.Fatalf("folder of installed package was moved: %v", err)
+	}
+	if err = root.Adopt(context.Background(), manual, "tool", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.Adopt(context.Background(), manual, "tool", "1.0"); !errors.Is(err, ipkg.ErrAlreadyInstalled) {
+		t.Errorf("package was adopted twice: %v", err)
+	}
+
+	installDir := filepath.Join(path, ipkg.PackageID("tool", "1.0"))
+	if !root.IsActive("tool", "1.0") {
+		t.Error("adopted package isn't active")
+	}
+	if dependency, err := root.IsDependency("tool", "1.0"); err != nil || dependency {
+		t.Errorf("adopted package isn't installed by user: %v", err)
+	}
+	pkg, file, err := root.FileOwner(filepath.Join(installDir, "bin", "tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Name != "tool" || pkg.Version != "1.0" || file.Type != ipkg.FileRegular {
+		t.Errorf("wrong owner of adopted file: %+v %+v", pkg, file)
+	}
+	report, err := root.Verify("tool", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Clean() {
+		t.Errorf("adopted package has problems: %+v", report.Issues)
+	}
+	problems, err := root.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("root with adopted package has problems: %v", problems)
+	}
+
+	if err = root.RemovePackage("tool", "1.0", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(installDir); !os.IsNotExist(err) {
+		t.Errorf("adopted package isn't removed: %v", err)
+	}
+}
+
+func TestFailedAdoptKeepsDirectory(t *testing.T) {
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	// Directory of user has its own IScript, which must be kept
+	manual := filepath.Join(path, "manual")
+	if err = os.MkdirAll(filepath.Join(manual, ".ira"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(manual, ".ira", "iscript")
+	if err = os.WriteFile(script, []byte("flag install\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Registering package fails
+	db, err := sql.Open("sqlite3", filepath.Join(path, "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec("CREATE TRIGGER refuse BEFORE INSERT ON packages BEGIN SELECT RAISE(ABORT, 'refused'); END")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = root.Adopt(context.Background(), manual, "tool", "1.0"); err == nil {
+		t.Fatal("package was adopted without registering")
+	}
+	entries, err := os.ReadDir(filepath.Join(manual, ".ira"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "iscript" {
+		t.Errorf("metadata of failed adoption is left: %v", entries)
+	}
+	if content, err := os.ReadFile(script); err != nil || string(content) != "flag install\n" {
+		t.Errorf("IScript of user is changed: %q (%v)", content, err)
+	}
+
+	// Metadata folder created by failed adoption is removed too
+	if err = os.RemoveAll(filepath.Join(manual, ".ira")); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.Adopt(context.Background(), manual, "tool", "1.0"); err == nil {
+		t.Fatal("package was adopted without registering")
+	}
+	if _, err = os.Lstat(filepath.Join(manual, ".ira")); !os.IsNotExist(err) {
+		t.Errorf("metadata folder of failed adoption is left: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package ipkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// BuildOptions sets up environment where package build scripts are executed
+type BuildOptions struct {
+	Timeout time.Duration // build script is killed after timeout, 0 means no timeout
+	Env     []string      // variables (KEY=value) passed to build script in addition to minimal environment
+	// Isolate runs build script in new Linux namespaces: without network and with read-only root filesystem.
+	// Only package directory stays writable. Isolation isn't supported on other systems.
+	Isolate bool
+}
+
+// WithBuildOptions sets up how package build scripts are executed
+func WithBuildOptions(opts BuildOptions) Option {
+	return func(r *Root) {
+		r.build = opts
+	}
+}
+
+// BuildLog returns path to log where output of build script of package name-$version is written
+func (r *Root) BuildLog(name, version string) string {
+	return filepath.Join(r.path, "logs", PackageID(name, version)+".build.log")
+}
+
+// buildPackage runs build script of unpacked package in pkgPath.
+// Script works in package directory with scrubbed environment, its stdout and stderr are written in build log
+func (r *Root) buildPackage(ctx context.Context, config *PkgConfig, pkgPath string) error {
+	pkgPath, err := filepath.Abs(pkgPath)
+	if err != nil {
+		return err
+	}
+	buildscriptPath := filepath.Join(pkgPath, ".ira", "build")
+	if runtime.GOOS == "windows" {
+		buildscriptPath += ".bat"
+	}
+	// Preparing build log
+	logPath := r.BuildLog(config.Name, config.Version)
+	if err = os.MkdirAll(filepath.Dir(logPath), os.ModePerm); err != nil {
+		return fmt.Errorf("creating logs folder: %v", err)
+	}
+	buildLog, err := os.Create(logPath)
+	if err != nil {
+		return fmt.Errorf("creating build log: %v", err)
+	}
+	defer buildLog.Close()
+	// Temporary files of build script are kept inside package
+	tmpDir := filepath.Join(pkgPath, ".ira", "tmp")
+	if err = os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		return fmt.Errorf("creating temporary folder: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if r.build.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.build.Timeout)
+		defer cancel()
+	}
+	buildscript := exec.CommandContext(ctx, buildscriptPath)
+	buildscript.Dir = pkgPath
+	buildscript.Env = append(buildEnv(pkgPath, tmpDir), r.build.Env...)
+	buildscript.Stdout = buildLog
+	buildscript.Stderr = buildLog
+	buildscript.WaitDelay = time.Second
+	if err = setupBuildProcess(buildscript, pkgPath, r.build); err != nil {
+		return err
+	}
+	logger := r.pkgLogger(config.Name, config.Version)
+	logger.Debug("running build script", "script", buildscriptPath, "log", logPath, "isolate", r.build.Isolate)
+	err = buildscript.Run()
+	if err != nil {
+		logger.Error("build script failed", "log", logPath, "error", err)
+	} else {
+		logger.Info("build script finished", "log", logPath)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("executing build script: %w", ctx.Err())
+	} else if err != nil {
+		return fmt.Errorf("executing build script: %w (output is written in %s)", err, logPath)
+	}
+	return nil
+}
+
+// buildEnv returns minimal environment of build script
+func buildEnv(pkgPath, tmpDir string) []string {
+	env := []string{
+		"HOME=" + pkgPath,
+		"IPKG_SRCDIR=" + pkgPath,
+		"TMPDIR=" + tmpDir,
+		"LANG=C",
+	}
+	if runtime.GOOS == "windows" {
+		// Scripts can't be run on Windows without system variables
+		for _, name := range []string{"SystemRoot", "ComSpec", "PATHEXT", "PATH"} {
+			env = append(env, name+"="+os.Getenv(name))
+		}
+		return append(env, "TEMP="+tmpDir, "TMP="+tmpDir)
+	}
+	return append(env, "PATH=/usr/local/bin:/usr/bin:/bin")
+}
@@ -0,0 +1,39 @@
+package ipkg
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolationScript makes every mount read-only except package directory ($1) and runs build script ($2).
+// It works in new mount namespace, so changes aren't visible outside. Options of mounts are kept,
+// because flags like nosuid and nodev are locked in user namespace.
+// Working directory is entered again, because old one stays on read-only mount
+const isolationScript = `mount --make-rprivate / && mount --bind "$1" "$1" || exit 1
+while read -r _ _ _ _ point options _; do
+	point=$(printf '%b' "$point")
+	[ "$point" = "$1" ] && continue
+	mount -o "remount,bind,$options,ro" "$point" || exit 1
+done < /proc/self/mountinfo
+cd "$1" && exec "$2"`
+
+// setupBuildProcess runs build script in its own process group, so cancellation kills all its children.
+// If isolation enabled, build script is started in new namespaces
+func setupBuildProcess(cmd *exec.Cmd, pkgPath string, opts BuildOptions) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	if !opts.Isolate {
+		return nil
+	}
+	cmd.Args = []string{"/bin/sh", "-c", isolationScript, "sh", pkgPath, cmd.Path}
+	cmd.Path = "/bin/sh"
+	cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET |
+		syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	// Current user becomes root inside namespace, it is needed for mounting
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	return nil
+}
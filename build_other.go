@@ -0,0 +1,16 @@
+//go:build !linux
+
+package ipkg
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+func setupBuildProcess(cmd *exec.Cmd, pkgPath string, opts BuildOptions) error {
+	if opts.Isolate {
+		return fmt.Errorf("build isolation is not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
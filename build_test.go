@@ -0,0 +1,120 @@
+package ipkg_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestBuildEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build scripts are shell scripts")
+	}
+	t.Setenv("IPKG_SECRET", "secret")
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	path := writeBuildPackage(t, "buildenv", `echo "dir=$(pwd)"
+echo "secret=$IPKG_SECRET"
+echo "error output" >&2`)
+	if err = root.InstallPackage(path, false); err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile(root.BuildLog("buildenv", "1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, _ := filepath.Abs(path)
+	if !strings.Contains(string(log), "dir="+abs+"\n") {
+		t.Errorf("build script wasn't run in package directory:\n%s", log)
+	}
+	if strings.Contains(string(log), "secret=secret") {
+		t.Error("environment wasn't scrubbed")
+	}
+	if !strings.Contains(string(log), "error output") {
+		t.Error("stderr wasn't written in build log")
+	}
+}
+
+func TestBuildTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build scripts are shell scripts")
+	}
+	root, err := ipkg.CreateRoot(t.TempDir(), ipkg.WithBuildOptions(ipkg.BuildOptions{Timeout: 200 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	path := writeBuildPackage(t, "slowbuild", "sleep 10")
+	start := time.Now()
+	err = root.InstallPackage(path, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("build script wasn't killed after timeout")
+	}
+}
+
+func TestBuildIsolation(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("isolation is supported only on Linux")
+	}
+	root, err := ipkg.CreateRoot(t.TempDir(), ipkg.WithBuildOptions(ipkg.BuildOptions{Isolate: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	outside := filepath.Join(t.TempDir(), "outside")
+	// Shared memory is usually a separate mount, which must be read-only too
+	var otherMount string
+	if shm, err := os.MkdirTemp("/dev/shm", "ipkg-test-"); err == nil {
+		defer os.RemoveAll(shm)
+		otherMount = filepath.Join(shm, "outside")
+	}
+	path := writeBuildPackage(t, "isolated", `touch built || exit 1
+touch `+outside+` && echo "root is writable"
+[ -n "`+otherMount+`" ] && touch "`+otherMount+`" && echo "submount is writable"
+exit 0`)
+	if err = root.InstallPackage(path, false); err != nil {
+		t.Skip("namespaces are not available:", err)
+	}
+	if _, err = os.Stat(filepath.Join(path, "built")); err != nil {
+		t.Error("package directory isn't writable")
+	}
+	if _, err = os.Stat(outside); err == nil {
+		t.Error("build script wrote outside package directory")
+	}
+	if otherMount == "" {
+		t.Log("/dev/shm isn't available, isolation of other mounts isn't checked")
+	} else if _, err = os.Stat(otherMount); err == nil {
+		t.Error("build script wrote in another mount")
+	}
+}
+
+// writeBuildPackage creates package with build script
+func writeBuildPackage(t *testing.T, name, script string) string {
+	t.Helper()
+	path := writePackage(t, t.TempDir(), name, "1.0", nil)
+	config, err := json.Marshal(ipkg.PkgConfig{Name: name, Version: "1.0", SupportLinux: true, SupportWindows: true, Build: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(path, ".ira", "config.json"), config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(path, ".ira", "build"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
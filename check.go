@@ -0,0 +1,236 @@
+package ipkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// InconsistencyKind is a kind of disagreement between database of root and its folder
+type InconsistencyKind string
+
+// Inconsistencies found by Check
+const (
+	MissingInstallation   InconsistencyKind = "missing-installation"   // package is in database, but its folder doesn't exist
+	OrphanInstallation    InconsistencyKind = "orphan-installation"    // folder name-$version exists, but package isn't in database
+	MultipleActive        InconsistencyKind = "multiple-active"        // several versions of package are marked as active
+	DanglingLink          InconsistencyKind = "dangling-link"          // activation link of active package is missing or leads nowhere
+	UnsatisfiedDependency InconsistencyKind = "unsatisfied-dependency" // required dependency of package isn't installed
+)
+
+// Inconsistency is a problem of package root found by Check
+type Inconsistency struct {
+	Kind    InconsistencyKind `json:"kind"`
+	Name    string            `json:"name"`
+	Version string            `json:"version,omitempty"` // empty for MultipleActive
+	Path    string            `json:"path,omitempty"`
+	Detail  string            `json:"detail,omitempty"`
+	Fixable bool              `json:"fixable"` // Fix can repair it without losing data
+}
+
+func (i Inconsistency) String() string {
+	id := i.Name
+	if i.Version != "" {
+		id = PackageID(i.Name, i.Version)
+	}
+	result := fmt.Sprintf("%s: %s", i.Kind, id)
+	if i.Path != "" {
+		result += " " + i.Path
+	}
+	if i.Detail != "" {
+		result += " (" + i.Detail + ")"
+	}
+	return result
+}
+
+// Check compares database of root with installed packages and returns found inconsistencies
+// sorted by package name and version
+func (r *Root) Check() ([]Inconsistency, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	rows, err := r.db.Query("SELECT name, version, active FROM packages")
+	if err != nil {
+		return nil, fmt.Errorf("in Check: %v", err)
+	}
+	defer rows.Close()
+	installed := make(map[string]bool)
+	active := make(map[string][]string)
+	var pkgs []PkgConfig
+	for rows.Next() {
+		var pkg PkgConfig
+		var isActive bool
+		if err = rows.Scan(&pkg.Name, &pkg.Version, &isActive); err != nil {
+			return nil, fmt.Errorf("in Check: %v", err)
+		}
+		installed[PackageID(pkg.Name, pkg.Version)] = true
+		if isActive {
+			active[pkg.Name] = append(active[pkg.Name], pkg.Version)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("in Check: %v", err)
+	}
+	rows.Close()
+
+	result := []Inconsistency{}
+	for _, pkg := range pkgs {
+		installDir := filepath.Join(r.path, PackageID(pkg.Name, pkg.Version))
+		if _, err = os.Stat(installDir); os.IsNotExist(err) {
+			result = append(result, Inconsistency{Kind: MissingInstallation, Name: pkg.Name, Version: pkg.Version,
+				Path: installDir, Fixable: r.hasCache(pkg.Name, pkg.Version)})
+		}
+		cfg, err := r.FindPackage(pkg.Name, pkg.Version)
+		if err != nil {
+			return nil, err
+		}
+		report, err := cfg.DependencyReport(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range report.Missing {
+			result = append(result, Inconsistency{Kind: UnsatisfiedDependency, Name: pkg.Name, Version: pkg.Version, Detail: id})
+		}
+	}
+	for name, versions := range active {
+		if len(versions) > 1 {
+			sort.Strings(versions)
+			result = append(result, Inconsistency{Kind: MultipleActive, Name: name,
+				Detail: "active versions: " + strings.Join(versions, ", "), Fixable: true})
+			continue
+		}
+		dangling, err := r.danglingLinks(name, versions[0])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, dangling...)
+	}
+	// Looking for installation folders which aren't in database
+	entries, err := os.ReadDir(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("in Check: %v", err)
+	}
+	for _, entry := range entries {
+		// Hidden folders are temporary folders of ipkg
+		if !entry.IsDir() || installed[entry.Name()] || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name, version, err := ParseID(entry.Name())
+		if err != nil {
+			continue // not an installation folder
+		}
+		result = append(result, Inconsistency{Kind: OrphanInstallation, Name: name, Version: version,
+			Path: filepath.Join(r.path, entry.Name())})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Version < result[j].Version
+	})
+	return result, nil
+}
+
+// danglingLinks checks activation links of active package name-$version.
+// Missing links can be created again, other broken links need user's attention
+func (r *Root) danglingLinks(name, version string) ([]Inconsistency, error) {
+	links, _, err := readActivationLog(filepath.Join(r.path, PackageID(name, version)))
+	if err != nil {
+		return nil, fmt.Errorf("checking links of %s: %w", PackageID(name, version), err)
+	}
+	var result []Inconsistency
+	for _, link := range links {
+		issue := r.verifyLink(name, link.Link)
+		if issue == nil {
+			continue
+		}
+		_, err = os.Lstat(link.Link)
+		result = append(result, Inconsistency{Kind: DanglingLink, Name: name, Version: version,
+			Path: link.Link, Detail: issue.Detail, Fixable: os.IsNotExist(err)})
+	}
+	return result, nil
+}
+
+// Fix checks root and repairs inconsistencies which can be fixed safely:
+// packages with missing folders are restored from cache, the newest version (or the one current/<name> points to)
+// stays active if several versions are marked as active and missing activation links are created.
+// Returns fixed inconsistencies, use Check to find remaining ones
+func (r *Root) Fix(ctx context.Context) ([]Inconsistency, error) {
+	unlock, err := r.lockAll()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	found, err := r.Check()
+	if err != nil {
+		return nil, err
+	}
+	fixed := []Inconsistency{}
+	activated := make(map[string]bool)
+	for _, problem := range found {
+		if !problem.Fixable {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return fixed, err
+		}
+		switch problem.Kind {
+		case MissingInstallation:
+			_, err = r.repair(ctx, problem.Name, problem.Version)
+		case MultipleActive:
+			var version string
+			version, err = r.chooseActive(problem.Name)
+			if err == nil {
+				err = r.activate(problem.Name, version)
+				activated[problem.Name] = true
+			}
+		case DanglingLink:
+			if !activated[problem.Name] {
+				err = r.activate(problem.Name, problem.Version)
+				activated[problem.Name] = true
+			}
+		}
+		if err != nil {
+			return fixed, fmt.Errorf("fixing %s: %w", problem, err)
+		}
+		r.logger.Info("inconsistency fixed", "problem", problem.String())
+		fixed = append(fixed, problem)
+	}
+	return fixed, nil
+}
+
+// chooseActive returns version of package name which must stay active, if several versions are marked as active
+func (r *Root) chooseActive(name string) (string, error) {
+	rows, err := r.db.Query("SELECT version FROM packages WHERE name = ? AND active = 1", name)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var pkgs []PkgConfig
+	for rows.Next() {
+		pkg := PkgConfig{Name: name}
+		if err = rows.Scan(&pkg.Version); err != nil {
+			return "", err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	if len(pkgs) == 0 {
+		return "", fmt.Errorf("package %s has no active versions", name)
+	}
+	current, ok := r.activeVersion(name)
+	for _, pkg := range pkgs {
+		if ok && pkg.Version == current {
+			return current, nil
+		}
+	}
+	SortByVersion.Sort(pkgs, true)
+	return pkgs[0].Version, nil
+}
@@ -0,0 +1,141 @@
+package ipkg_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	for _, pkg := range []string{
+		writeActivatedPackage(t, dir, links, "1.0", "tool"),
+		writeActivatedPackage(t, dir, links, "2.0", "tool"),
+		writePackage(t, dir, "lib", "1.0", nil),
+	} {
+		if err = root.InstallPackage(pkg, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	problems, err := root.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("consistent root has problems: %v", problems)
+	}
+
+	// Breaking root
+	db, err := sql.Open("sqlite3", filepath.Join(path, "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	changes := []error{
+		os.RemoveAll(filepath.Join(path, ipkg.PackageID("lib", "1.0"))),
+		os.Mkdir(filepath.Join(path, ipkg.PackageID("manual", "1.0")), os.ModePerm),
+		os.Remove(filepath.Join(links, "tool")),
+	}
+	_, err = db.Exec("UPDATE packages SET active = 1 WHERE name = 'tool'")
+	changes = append(changes, err)
+	_, err = db.Exec("INSERT INTO packages (name, version, dependencies, by_user) VALUES ('app', '1.0', 'missing-$1.0(!)', 1)")
+	changes = append(changes, err)
+	for _, err := range changes {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	problems, err = root.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ipkg.Inconsistency{
+		{Kind: ipkg.MissingInstallation, Name: "app", Version: "1.0", Path: filepath.Join(path, ipkg.PackageID("app", "1.0"))},
+		{Kind: ipkg.UnsatisfiedDependency, Name: "app", Version: "1.0", Detail: "missing-$1.0"},
+		{Kind: ipkg.MissingInstallation, Name: "lib", Version: "1.0", Path: filepath.Join(path, ipkg.PackageID("lib", "1.0")), Fixable: true},
+		{Kind: ipkg.OrphanInstallation, Name: "manual", Version: "1.0", Path: filepath.Join(path, ipkg.PackageID("manual", "1.0"))},
+		{Kind: ipkg.MultipleActive, Name: "tool", Detail: "active versions: 1.0, 2.0", Fixable: true},
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+	for i := range expected {
+		if problems[i] != expected[i] {
+			t.Errorf("wrong problem %d: got %+v, expected %+v", i, problems[i], expected[i])
+		}
+	}
+
+	fixed, err := root.Fix(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixed) != 2 {
+		t.Errorf("wrong fixed problems: %v", fixed)
+	}
+	problems, err = root.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 3 {
+		t.Errorf("wrong problems after fixing: %v", problems)
+	}
+	if root.IsActive("tool", "1.0") || !root.IsActive("tool", "2.0") {
+		t.Error("wrong version of tool stays active")
+	}
+	if content, err := os.ReadFile(filepath.Join(links, "tool")); err != nil || string(content) != "2.0" {
+		t.Errorf("link of tool leads to %q (%v)", content, err)
+	}
+	if _, err = os.Stat(filepath.Join(path, ipkg.PackageID("lib", "1.0"), "bin", "lib")); err != nil {
+		t.Errorf("lib isn't restored: %v", err)
+	}
+}
+
+func TestFixChoosesNewest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	for _, version := range []string{"2.0", "1.0"} {
+		if err = root.InstallPackage(writeActivatedPackage(t, dir, links, version, "tool"), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Both versions are marked as active and there is no link to active version, so the newest one is chosen
+	db, err := sql.Open("sqlite3", filepath.Join(path, "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("UPDATE packages SET active = 1 WHERE name = 'tool'"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(path, "current", "tool")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = root.Fix(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if root.IsActive("tool", "1.0") || !root.IsActive("tool", "2.0") {
+		t.Error("the newest version of tool isn't chosen")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+type Adopt struct {
+	flagSet *flag.FlagSet
+	ready   bool
+	dir     string
+	name    string
+	version string
+}
+
+func NewAdoptCommand() *Adopt {
+	adopt := &Adopt{
+		flagSet: flag.NewFlagSet("adopt", flag.ContinueOnError),
+		ready:   false,
+	}
+	adopt.flagSet.StringVar(&adopt.name, "name", "", "Name of adopted package")
+	adopt.flagSet.StringVar(&adopt.version, "version", "", "Version of adopted package")
+	return adopt
+}
+
+func (a *Adopt) Init(args []string) error {
+	err := a.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	// Flags can be placed after directory too
+	if a.flagSet.NArg() > 0 {
+		a.dir = a.flagSet.Arg(0)
+		if err = a.flagSet.Parse(a.flagSet.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if a.dir == "" || a.flagSet.NArg() != 0 || a.name == "" || a.version == "" {
+		return fmt.Errorf("usage: adopt -name name -version version dir")
+	}
+	a.ready = true
+	return nil
+}
+
+func (a *Adopt) Name() string { return a.flagSet.Name() }
+
+func (a *Adopt) Run() error {
+	if !a.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	err = root.Adopt(config.ctx, a.dir, a.name, a.version)
+	if err != nil {
+		return err
+	}
+	color.Green("Directory %s adopted as package %s", a.dir, ipkg.PackageID(a.name, a.version))
+	return nil
+}
@@ -1,7 +1,66 @@
 package main
 
-import "github.com/ira-package-manager/ipkg"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ira-package-manager/ipkg"
+)
 
 var config struct {
-	root *ipkg.Root
+	ctx         context.Context // cancelled when user interrupts program
+	root        *ipkg.Root
+	lockTimeout time.Duration
+	build       ipkg.BuildOptions
+	logger      *slog.Logger
+}
+
+// loadRoot opens package root in user's home directory if root wasn't opened before
+func loadRoot() (*ipkg.Root, error) {
+	if config.root != nil {
+		return config.root, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(home, ".ira")
+	if _, err := os.Stat(filepath.Join(path, "db.sqlite3")); os.IsNotExist(err) {
+		config.root, err = ipkg.CreateRoot(path, rootOptions()...)
+	} else {
+		config.root, err = ipkg.OpenRoot(path, rootOptions()...)
+	}
+	return config.root, err
+}
+
+// rootOptions returns options of package root set by global flags
+func rootOptions() []ipkg.Option {
+	return []ipkg.Option{
+		ipkg.WithLockTimeout(config.lockTimeout),
+		ipkg.WithBuildOptions(config.build),
+		ipkg.WithObserver(observer),
+		ipkg.WithLogger(config.logger),
+	}
+}
+
+// newLogger creates logger writing in stderr records with level not less than level in format (text or json)
+func newLogger(level, format string) (*slog.Logger, error) {
+	opts := new(slog.HandlerOptions)
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts.Level = minLevel
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: expected text or json", format)
+	}
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+// errInconsistent is returned when doctor finds problems of root
+var errInconsistent = errors.New("package root is inconsistent")
+
+type Doctor struct {
+	flagSet *flag.FlagSet
+	ready   bool
+	fix     bool
+}
+
+func NewDoctorCommand() *Doctor {
+	doctor := &Doctor{
+		flagSet: flag.NewFlagSet("doctor", flag.ContinueOnError),
+		ready:   false,
+	}
+	doctor.flagSet.BoolVar(&doctor.fix, "fix", false, "If specified, problems which can be fixed safely will be fixed")
+	return doctor
+}
+
+func (d *Doctor) Init(args []string) error {
+	err := d.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	if d.flagSet.NArg() != 0 {
+		return fmt.Errorf("usage: doctor [-fix]")
+	}
+	d.ready = true
+	return nil
+}
+
+func (d *Doctor) Name() string { return d.flagSet.Name() }
+
+func (d *Doctor) Run() error {
+	if !d.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	if d.fix {
+		fixed, err := root.Fix(config.ctx)
+		for _, problem := range fixed {
+			color.Green("  fixed  %s", problem)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	problems, err := root.Check()
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		color.Green("No problems found")
+		return nil
+	}
+	for _, problem := range problems {
+		switch {
+		case problem.Fixable:
+			fmt.Printf("  %s (can be fixed with -fix)\n", problem)
+		case problem.Kind == ipkg.OrphanInstallation:
+			fmt.Printf("  %s (can be registered with adopt)\n", problem)
+		default:
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+	color.Red("%d problems found", len(problems))
+	return errInconsistent
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+// Exit codes of ipkg, so scripts can distinguish errors without parsing messages
+const (
+	exitError               = 1 // error without special code
+	exitUsage               = 2 // invalid global flags
+	exitNotInstalled        = 3
+	exitAlreadyInstalled    = 4
+	exitMissingDependencies = 5
+	exitUnsupportedPlatform = 6
+	exitNotAPackage         = 7
+	exitHasDependents       = 8
+	exitLocked              = 9
+	exitLinkConflict        = 10
+	exitDrift               = 11 // verify found changed files
+	exitInconsistent        = 12 // doctor found problems of root
+	exitNotOwned            = 13 // owns got path which doesn't belong to any package
+	exitChecksumMismatch    = 14 // package differs from lockfile
+)
+
+// exitCode returns exit code describing err
+func exitCode(err error) int {
+	var missingErr *ipkg.MissingDependenciesError
+	var dependentsErr *ipkg.DependentsError
+	var lockedErr *ipkg.LockedError
+	var conflictErr *ipkg.LinkConflictError
+	var checksumErr *ipkg.ChecksumError
+	switch {
+	case errors.Is(err, errDrift):
+		return exitDrift
+	case errors.Is(err, errInconsistent):
+		return exitInconsistent
+	case errors.Is(err, ipkg.ErrNotInstalled):
+		return exitNotInstalled
+	case errors.Is(err, ipkg.ErrAlreadyInstalled):
+		return exitAlreadyInstalled
+	case errors.As(err, &missingErr):
+		return exitMissingDependencies
+	case errors.Is(err, ipkg.ErrUnsupportedPlatform):
+		return exitUnsupportedPlatform
+	case errors.Is(err, ipkg.ErrNotAPackage):
+		return exitNotAPackage
+	case errors.As(err, &dependentsErr):
+		return exitHasDependents
+	case errors.As(err, &lockedErr):
+		return exitLocked
+	case errors.As(err, &conflictErr):
+		return exitLinkConflict
+	case errors.Is(err, ipkg.ErrNotOwned):
+		return exitNotOwned
+	case errors.As(err, &checksumErr):
+		return exitChecksumMismatch
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ira-package-manager/gobetter/cmd"
+)
+
+type Graph struct {
+	flagSet *flag.FlagSet
+	ready   bool
+	name    string
+	format  string
+}
+
+func NewGraphCommand() *Graph {
+	graph := &Graph{
+		flagSet: flag.NewFlagSet("graph", flag.ContinueOnError),
+		ready:   false,
+	}
+	graph.flagSet.StringVar(&graph.format, "format", "dot", "Output format: dot or json")
+	return graph
+}
+
+func (g *Graph) Init(args []string) error {
+	err := g.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	// Flags can be placed after package name too
+	if g.flagSet.NArg() > 0 {
+		g.name = g.flagSet.Arg(0)
+		if err = g.flagSet.Parse(g.flagSet.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if g.flagSet.NArg() != 0 {
+		return fmt.Errorf("usage: graph [flags] [name]")
+	}
+	if g.format != "dot" && g.format != "json" {
+		return fmt.Errorf("unknown graph format %q", g.format)
+	}
+	g.ready = true
+	return nil
+}
+
+func (g *Graph) Name() string { return g.flagSet.Name() }
+
+func (g *Graph) Run() error {
+	if !g.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	graph, err := root.DependencyGraph(g.name)
+	if err != nil {
+		return err
+	}
+	if g.format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(graph)
+	}
+	return graph.WriteDOT(os.Stdout)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+type Info struct {
+	flagSet *flag.FlagSet
+	ready   bool
+	name    string
+	version string
+}
+
+func NewInfoCommand() *Info {
+	return &Info{
+		flagSet: flag.NewFlagSet("info", flag.ContinueOnError),
+		ready:   false,
+	}
+}
+
+func (in *Info) Init(args []string) error {
+	err := in.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	if in.flagSet.NArg() != 2 {
+		return fmt.Errorf("usage: info name version")
+	}
+	in.name = in.flagSet.Arg(0)
+	in.version = in.flagSet.Arg(1)
+	in.ready = true
+	return nil
+}
+
+func (in *Info) Name() string { return in.flagSet.Name() }
+
+func (in *Info) Run() error {
+	if !in.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	info, err := root.Info(in.name, in.version)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Package:   ", ipkg.PackageID(info.Name, info.Version))
+	fmt.Println("Active:    ", info.Active)
+	if !info.ActivatedAt.IsZero() {
+		fmt.Println("Activated: ", info.ActivatedAt.Local().Format(time.DateTime))
+	}
+	if info.AsDependency {
+		fmt.Println("Installed:  as dependency")
+	} else {
+		fmt.Println("Installed:  by user")
+	}
+	var required []string
+	for _, id := range sortedKeys(info.Dependencies) {
+		if info.Dependencies[id] {
+			required = append(required, id)
+		}
+	}
+	if len(required) != 0 {
+		fmt.Println("Requires:")
+		for _, id := range required {
+			fmt.Println("  " + id)
+		}
+	}
+	if len(info.Optional) != 0 {
+		fmt.Println("Optional features:")
+		for _, id := range sortedKeys(info.Optional) {
+			if info.Optional[id] {
+				color.Green("  [enabled]  %s", id)
+			} else {
+				fmt.Printf("  [disabled] %s\n", id)
+			}
+		}
+	}
+	if len(info.Dependents) != 0 {
+		fmt.Println("Required by:")
+		for _, pkg := range info.Dependents {
+			fmt.Println("  " + ipkg.PackageID(pkg.Name, pkg.Version))
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns keys of m in alphabetical order
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -15,6 +16,8 @@ type Install struct {
 	ready        bool
 	path         string
 	asDependency bool
+	withOptional bool
+	sources      []string
 }
 
 func NewInstallCommand() *Install {
@@ -23,6 +26,11 @@ func NewInstallCommand() *Install {
 		ready:   false,
 	}
 	install.flagSet.BoolVar(&install.asDependency, "dependency", false, "If specified, package will be installed as dependency")
+	install.flagSet.BoolVar(&install.withOptional, "with-optional", false, "If specified, available optional dependencies will be installed without asking")
+	install.flagSet.Func("source", "Directory with packages where optional dependencies are searched (can be repeated, default is directory of package)", func(dir string) error {
+		install.sources = append(install.sources, dir)
+		return nil
+	})
 
 	return install
 }
@@ -33,6 +41,9 @@ func (i *Install) Init(args []string) error {
 		return err
 	}
 	i.path = i.flagSet.Arg(0)
+	if len(i.sources) == 0 {
+		i.sources = []string{filepath.Dir(i.path)}
+	}
 	i.ready = true
 	return nil
 }
@@ -45,27 +56,26 @@ func (i *Install) Run() error {
 	if !i.ready {
 		return cmd.ErrNotReady
 	}
-	if config.root == nil {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return err
-		}
-		if _, err := os.Stat(filepath.Join(home, ".ira", "db.sqlite3")); os.IsNotExist(err) {
-			config.root, err = ipkg.CreateRoot(filepath.Join(home, ".ira", "db.sqlite3"))
-			if err != nil {
-				return err
-			}
-		} else {
-			config.root, err = ipkg.OpenRoot(filepath.Join(home, ".ira", "db.sqlite3"))
-			if err != nil {
-				return err
-			}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	opts := ipkg.InstallOptions{AsDependency: i.asDependency, Sources: i.sources, WithOptional: i.withOptional}
+	if !i.withOptional && isInteractive() {
+		opts.AcceptOptional = func(id string) bool {
+			return confirm(fmt.Sprintf("Optional dependency %s is available. Install it?", id))
 		}
 	}
-	err := config.root.InstallPackage(i.path, i.asDependency)
+	err = root.InstallPackageContext(config.ctx, i.path, opts)
 	if err != nil {
 		return err
 	}
 	color.Green("Package %s succesifully installed", i.path)
 	return nil
 }
+
+// isInteractive checks if standard input is a terminal, so user can be asked questions
+func isInteractive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
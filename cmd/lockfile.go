@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+type Lock struct {
+	flagSet *flag.FlagSet
+	ready   bool
+	action  string // export or apply
+	path    string // lockfile applied
+	sources []string
+}
+
+func NewLockCommand() *Lock {
+	lock := &Lock{
+		flagSet: flag.NewFlagSet("lock", flag.ContinueOnError),
+		ready:   false,
+	}
+	lock.flagSet.Func("source", "Directory with packages installed by apply (can be repeated, default is directory of lockfile)", func(dir string) error {
+		lock.sources = append(lock.sources, dir)
+		return nil
+	})
+	return lock
+}
+
+func (l *Lock) Init(args []string) error {
+	err := l.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	// Flags can be placed after action too
+	if l.flagSet.NArg() > 0 {
+		l.action = l.flagSet.Arg(0)
+		if err = l.flagSet.Parse(l.flagSet.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	switch {
+	case l.action == "export" && l.flagSet.NArg() == 0:
+	case l.action == "apply" && l.flagSet.NArg() == 1:
+		l.path = l.flagSet.Arg(0)
+		if len(l.sources) == 0 {
+			l.sources = []string{filepath.Dir(l.path)}
+		}
+	default:
+		return fmt.Errorf("usage: lock export > ipkg.lock or lock apply [flags] ipkg.lock")
+	}
+	l.ready = true
+	return nil
+}
+
+func (l *Lock) Name() string { return l.flagSet.Name() }
+
+func (l *Lock) Run() error {
+	if !l.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	if l.action == "export" {
+		lockfile, err := root.ExportLockfile()
+		if err != nil {
+			return err
+		}
+		return lockfile.Write(os.Stdout)
+	}
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	lockfile, err := ipkg.ReadLockfile(file)
+	if err != nil {
+		return err
+	}
+	if err = root.ApplyLockfile(config.ctx, lockfile, l.sources); err != nil {
+		return err
+	}
+	color.Green("Package root matches %s", l.path)
+	return nil
+}
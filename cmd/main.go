@@ -1,20 +1,64 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 
 	"github.com/ira-package-manager/gobetter/cmd"
 )
 
 func main() {
-	err := cmd.RunSubcommand(
+	// Global flags are placed before sub-command
+	flags := flag.NewFlagSet("ipkg", flag.ExitOnError)
+	flags.DurationVar(&config.lockTimeout, "lock-timeout", 0, "How long to wait for package root locked by another process (negative value means waiting forever)")
+	flags.DurationVar(&config.build.Timeout, "build-timeout", 0, "Build scripts are killed after this timeout (0 means no timeout)")
+	flags.BoolVar(&config.build.Isolate, "isolate-build", false, "Run build scripts without network and with read-only root filesystem (Linux only)")
+	logLevel := flags.String("log-level", "warn", "Minimal level of logged records: debug, info, warn or error")
+	logFormat := flags.String("log-format", "text", "Format of log written in stderr: text or json")
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, cmd.ErrNoSubcommand)
+		os.Exit(exitUsage)
+	}
+	var err error
+	config.logger, err = newLogger(*logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	// Ctrl-C cancels running operation
+	var stop context.CancelFunc
+	config.ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = cmd.RunSubcommand(
 		[]cmd.Interface{
 			NewInstallCommand(),
 			NewOpenRootCommand(),
-		}, os.Args[1:])
+			NewRemoveCommand(),
+			NewRecoverCommand(),
+			NewInfoCommand(),
+			NewGraphCommand(),
+			NewWhyCommand(),
+			NewLockCommand(),
+			NewOwnsCommand(),
+			NewVerifyCommand(),
+			NewRepairCommand(),
+			NewDoctorCommand(),
+			NewAdoptCommand(),
+		}, append([]string{os.Args[0]}, flags.Args()...))
+	if config.root != nil {
+		if closeErr := config.root.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("closing package root: %w", closeErr)
+		}
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		stop()
+		os.Exit(exitCode(err))
 	}
 }
@@ -39,14 +39,14 @@ func (or *OpenRoot) Run() error {
 		return cmd.ErrNotReady
 	}
 	if _, err := os.Stat(or.path); os.IsNotExist(err) {
-		root, err := ipkg.CreateRoot(or.path)
+		root, err := ipkg.CreateRoot(or.path, rootOptions()...)
 		if err != nil {
 			return err
 		}
 		color.Green("Root %s succesifully created", or.path)
 		config.root = root
 	} else {
-		root, err := ipkg.CreateRoot(or.path)
+		root, err := ipkg.CreateRoot(or.path, rootOptions()...)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+type Owns struct {
+	flagSet *flag.FlagSet
+	ready   bool
+	path    string
+}
+
+func NewOwnsCommand() *Owns {
+	return &Owns{
+		flagSet: flag.NewFlagSet("owns", flag.ContinueOnError),
+		ready:   false,
+	}
+}
+
+func (o *Owns) Init(args []string) error {
+	err := o.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	if o.flagSet.NArg() != 1 {
+		return fmt.Errorf("usage: owns path")
+	}
+	o.path = o.flagSet.Arg(0)
+	o.ready = true
+	return nil
+}
+
+func (o *Owns) Name() string { return o.flagSet.Name() }
+
+func (o *Owns) Run() error {
+	if !o.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	pkg, file, err := root.FileOwner(o.path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is owned by %s (%s)\n", file.Path, ipkg.PackageID(pkg.Name, pkg.Version), file.Type)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/ipkg"
+)
+
+// progress renders events of package operations in terminal
+type progress struct {
+	files   int          // files installed in current operation
+	skipped []ipkg.Event // activation links left untouched, commands report them when they finish
+}
+
+// observer is progress of operations of the opened root
+var observer = new(progress)
+
+func (p *progress) OnEvent(event ipkg.Event) {
+	id := ipkg.PackageID(event.Name, event.Version)
+	if event.Name == "" {
+		id = "package" // archive isn't extracted yet
+	}
+	switch event.Type {
+	case ipkg.EventPhaseStarted:
+		p.files = 0
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", color.BlueString("==>"), id, event.Phase)
+	case ipkg.EventBytesExtracted:
+		fmt.Fprintf(os.Stderr, "\r    %s", progressBar(event.Bytes, event.Total, 30))
+		if event.Bytes == event.Total {
+			fmt.Fprintln(os.Stderr)
+		}
+	case ipkg.EventFileInstalled:
+		p.files++
+	case ipkg.EventLinkSkipped:
+		p.skipped = append(p.skipped, event)
+	case ipkg.EventPhaseFinished:
+		if event.Err != nil {
+			fmt.Fprintf(os.Stderr, "    %s\n", color.RedString("failed"))
+		} else if event.Phase == ipkg.PhaseIScript && p.files != 0 {
+			fmt.Fprintf(os.Stderr, "    %d files installed\n", p.files)
+		}
+	}
+}
+
+// takeSkipped returns activation links skipped since previous call
+func (p *progress) takeSkipped() []ipkg.Event {
+	skipped := p.skipped
+	p.skipped = nil
+	return skipped
+}
+
+// progressBar returns bar of width characters filled according to done/total
+func progressBar(done, total int64, width int) string {
+	if total <= 0 {
+		return ""
+	}
+	filled := int(done * int64(width) / total)
+	return fmt.Sprintf("[%s%s] %3d%%", strings.Repeat("#", filled), strings.Repeat(" ", width-filled), done*100/total)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+type Recover struct {
+	flagSet *flag.FlagSet
+	ready   bool
+}
+
+func NewRecoverCommand() *Recover {
+	return &Recover{
+		flagSet: flag.NewFlagSet("recover", flag.ContinueOnError),
+		ready:   false,
+	}
+}
+
+func (rc *Recover) Init(args []string) error {
+	err := rc.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	rc.ready = true
+	return nil
+}
+
+func (rc *Recover) Name() string { return rc.flagSet.Name() }
+
+func (rc *Recover) Run() error {
+	if !rc.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	recovered, err := root.Recover()
+	if err != nil {
+		return err
+	}
+	if len(recovered) == 0 {
+		color.Green("No interrupted operations found")
+		return nil
+	}
+	for _, op := range recovered {
+		fmt.Printf("Recovered %s of %s (interrupted at %s step)\n", op.Kind, ipkg.PackageID(op.Name, op.Version), op.Step)
+	}
+	color.Green("%d interrupted operations recovered", len(recovered))
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+type Remove struct {
+	flagSet            *flag.FlagSet
+	ready              bool
+	name               string
+	version            string
+	removeDependencies bool
+	cascade            bool
+	yes                bool
+	purge              bool
+}
+
+func NewRemoveCommand() *Remove {
+	remove := &Remove{
+		flagSet: flag.NewFlagSet("remove", flag.ContinueOnError),
+		ready:   false,
+	}
+	remove.flagSet.BoolVar(&remove.removeDependencies, "dependencies", true, "If specified, unused dependencies will be removed too")
+	remove.flagSet.BoolVar(&remove.cascade, "cascade", false, "If specified, packages requiring this package will be removed too")
+	remove.flagSet.BoolVar(&remove.yes, "yes", false, "If specified, removal plan won't be confirmed")
+	remove.flagSet.BoolVar(&remove.purge, "purge", false, "If specified, edited configuration files will be removed too")
+	return remove
+}
+
+func (r *Remove) Init(args []string) error {
+	err := r.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	if r.flagSet.NArg() != 2 {
+		return fmt.Errorf("usage: remove [flags] name version")
+	}
+	r.name = r.flagSet.Arg(0)
+	r.version = r.flagSet.Arg(1)
+	r.ready = true
+	return nil
+}
+
+func (r *Remove) Name() string { return r.flagSet.Name() }
+
+func (r *Remove) Run() error {
+	if !r.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	opts := ipkg.RemoveOptions{
+		RemoveDependencies: r.removeDependencies,
+		Cascade:            r.cascade,
+		Purge:              r.purge,
+	}
+	plan, err := root.RemovalPlan(r.name, r.version, opts)
+	if err != nil {
+		return err
+	}
+	// Showing plan before removing anything
+	if len(plan) > 1 {
+		fmt.Println("The following packages will be removed:")
+		for _, pkg := range plan {
+			fmt.Println("  " + ipkg.PackageID(pkg.Name, pkg.Version))
+		}
+		if !r.yes && !confirm("Continue?") {
+			return fmt.Errorf("removing cancelled")
+		}
+	}
+	err = root.RemovePackageContext(config.ctx, r.name, r.version, opts)
+	// Links changed by user or taken by other packages are kept, user must decide what to do with them
+	for _, link := range observer.takeSkipped() {
+		color.Yellow("Link %s of %s is kept: %s", link.File, ipkg.PackageID(link.Name, link.Version), link.Detail)
+	}
+	if err != nil {
+		return err
+	}
+	color.Green("Package %s succesifully removed", ipkg.PackageID(r.name, r.version))
+	return nil
+}
+
+// confirm asks user a yes/no question, default answer is no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
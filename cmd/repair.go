@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+type Repair struct {
+	flagSet *flag.FlagSet
+	ready   bool
+	name    string
+	version string
+}
+
+func NewRepairCommand() *Repair {
+	return &Repair{
+		flagSet: flag.NewFlagSet("repair", flag.ContinueOnError),
+		ready:   false,
+	}
+}
+
+func (r *Repair) Init(args []string) error {
+	err := r.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	if r.flagSet.NArg() != 2 {
+		return fmt.Errorf("usage: repair name version")
+	}
+	r.name = r.flagSet.Arg(0)
+	r.version = r.flagSet.Arg(1)
+	r.ready = true
+	return nil
+}
+
+func (r *Repair) Name() string { return r.flagSet.Name() }
+
+func (r *Repair) Run() error {
+	if !r.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	report, err := root.Repair(config.ctx, r.name, r.version)
+	if err != nil {
+		return err
+	}
+	for _, issue := range report.Repaired {
+		fmt.Printf("  repaired   %s (%s)\n", issue.Path, issue.Problem)
+	}
+	for _, issue := range report.Preserved {
+		fmt.Printf("  preserved  %s (configuration file)\n", issue.Path)
+	}
+	id := ipkg.PackageID(r.name, r.version)
+	if report.Remaining.Drift() {
+		color.Yellow("%s: %d problems remain", id, len(report.Remaining.Issues))
+		for _, issue := range report.Remaining.Issues {
+			fmt.Printf("  %-12s %s\n", issue.Problem, issue.Path)
+		}
+		return errDrift
+	}
+	color.Green("%s: OK", id)
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+// errDrift is returned when installed files differ from recorded ones
+var errDrift = errors.New("installed files were changed")
+
+type Verify struct {
+	flagSet *flag.FlagSet
+	ready   bool
+	all     bool
+	name    string
+	version string
+}
+
+func NewVerifyCommand() *Verify {
+	verify := &Verify{
+		flagSet: flag.NewFlagSet("verify", flag.ContinueOnError),
+		ready:   false,
+	}
+	verify.flagSet.BoolVar(&verify.all, "all", false, "If specified, all installed packages are verified")
+	return verify
+}
+
+func (v *Verify) Init(args []string) error {
+	err := v.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case v.all && v.flagSet.NArg() == 0:
+	case !v.all && v.flagSet.NArg() == 2:
+		v.name = v.flagSet.Arg(0)
+		v.version = v.flagSet.Arg(1)
+	default:
+		return fmt.Errorf("usage: verify name version or verify -all")
+	}
+	v.ready = true
+	return nil
+}
+
+func (v *Verify) Name() string { return v.flagSet.Name() }
+
+func (v *Verify) Run() error {
+	if !v.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	var reports []*ipkg.VerifyReport
+	if v.all {
+		reports, err = root.VerifyAll()
+	} else {
+		var report *ipkg.VerifyReport
+		report, err = root.Verify(v.name, v.version)
+		reports = append(reports, report)
+	}
+	if err != nil {
+		return err
+	}
+	drift := false
+	for _, report := range reports {
+		id := ipkg.PackageID(report.Name, report.Version)
+		if report.Clean() {
+			color.Green("%s: OK", id)
+			continue
+		}
+		// Edited configuration files are shown, but they aren't a drift
+		if report.Drift() {
+			drift = true
+			color.Red("%s: %d problems", id, len(report.Issues))
+		} else {
+			color.Yellow("%s: %d configuration files edited", id, len(report.Issues))
+		}
+		for _, issue := range report.Issues {
+			if issue.Detail != "" {
+				fmt.Printf("  %-12s %s (%s)\n", issue.Problem, issue.Path, issue.Detail)
+			} else {
+				fmt.Printf("  %-12s %s\n", issue.Problem, issue.Path)
+			}
+		}
+	}
+	if drift {
+		return errDrift
+	}
+	return nil
+}
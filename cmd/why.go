@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/ira-package-manager/gobetter/cmd"
+	"github.com/ira-package-manager/ipkg"
+)
+
+type Why struct {
+	flagSet *flag.FlagSet
+	ready   bool
+	name    string
+	version string
+}
+
+func NewWhyCommand() *Why {
+	return &Why{
+		flagSet: flag.NewFlagSet("why", flag.ContinueOnError),
+		ready:   false,
+	}
+}
+
+func (w *Why) Init(args []string) error {
+	err := w.flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+	if w.flagSet.NArg() != 2 {
+		return fmt.Errorf("usage: why name version")
+	}
+	w.name = w.flagSet.Arg(0)
+	w.version = w.flagSet.Arg(1)
+	w.ready = true
+	return nil
+}
+
+func (w *Why) Name() string { return w.flagSet.Name() }
+
+func (w *Why) Run() error {
+	if !w.ready {
+		return cmd.ErrNotReady
+	}
+	root, err := loadRoot()
+	if err != nil {
+		return err
+	}
+	chains, err := root.Why(w.name, w.version)
+	if err != nil {
+		return err
+	}
+	id := ipkg.PackageID(w.name, w.version)
+	if len(chains) == 0 {
+		color.Yellow("Package %s is orphaned: no package installed by user depends on it", id)
+		return nil
+	}
+	for _, chain := range chains {
+		if len(chain) == 1 {
+			fmt.Printf("%s is installed by user\n", id)
+		} else {
+			fmt.Println(strings.Join(chain, " -> "))
+		}
+	}
+	return nil
+}
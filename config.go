@@ -1,11 +1,11 @@
 package ipkg
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 )
 
@@ -17,33 +17,77 @@ type PkgConfig struct {
 	SupportWindows bool
 	SupportLinux   bool
 	Build          bool // true when package needs to be built
+	// ConfigFiles are configuration files of package, slash separated paths relative to installation folder.
+	// They can be edited by user, so repair doesn't overwrite them
+	ConfigFiles []string `json:",omitempty"`
+}
+
+// DependencyReport describes which dependencies of package are installed in root
+type DependencyReport struct {
+	Missing         []string // IDs of required dependencies which are not installed
+	MissingOptional []string // IDs of optional dependencies which are not installed
+	// Rejected contains installed versions of missing dependencies (required and optional),
+	// which were considered but don't match required version. Keys are IDs of dependencies
+	Rejected map[string][]string
+}
+
+// Satisfied returns true when all required dependencies are installed
+func (report *DependencyReport) Satisfied() bool {
+	return len(report.Missing) == 0
 }
 
 // CheckDependencies checks if all dependencies are statisfied or not.
 // root is a package root used to package installation.
-// Returns boolean means success of check or fail and error if there were some errors
+// Returns boolean means success of check or fail and error if there were some errors.
+// Use DependencyReport to find out which dependencies are missing
 func (cfg *PkgConfig) CheckDependencies(root *Root) (bool, error) {
-	for id, isRequired := range cfg.Dependencies { // for each required dependency...
-		if !isRequired {
-			continue
+	report, err := cfg.DependencyReport(root)
+	if err != nil {
+		return false, err
+	}
+	return report.Satisfied(), nil
+}
+
+// DependencyReport checks all dependencies of package in root and reports every missing one.
+// IDs in report are sorted
+func (cfg *PkgConfig) DependencyReport(root *Root) (*DependencyReport, error) {
+	report := &DependencyReport{Rejected: make(map[string][]string)}
+	for id, isRequired := range cfg.Dependencies {
+		// Parsing ID for name and version...
+		name, version, err := ParseID(id)
+		if err != nil {
+			return nil, err
 		}
-		// ...parsing ID for name and version...
-		var name, version string
-		_, err := fmt.Sscanf(id, "%s-$%s", &name, &version)
+		// ...and getting all installed versions of dependency
+		installed, err := root.FindPackagesByName(name)
 		if err != nil {
-			return false, fmt.Errorf("parsing id %s: %v", id, err)
+			return nil, fmt.Errorf("finding dependency %s in database: %v", id, err)
 		}
-
-		// ...and trying to get package from database
-		_, err = root.FindPackage(name, version)
-		if err == sql.ErrNoRows { // If got no rows
-			return false, nil
+		var rejected []string
+		found := false
+		for _, pkg := range installed {
+			if pkg.Version == version {
+				found = true
+				break
+			}
+			rejected = append(rejected, pkg.Version)
 		}
-		if err != nil {
-			return false, fmt.Errorf("finding dependency %s in database: %v", id, err)
+		if found {
+			continue
+		}
+		if isRequired {
+			report.Missing = append(report.Missing, id)
+		} else {
+			report.MissingOptional = append(report.MissingOptional, id)
+		}
+		if len(rejected) != 0 {
+			sort.Slice(rejected, func(i, j int) bool { return compareVersions(rejected[i], rejected[j]) < 0 })
+			report.Rejected[id] = rejected
 		}
 	}
-	return true, nil
+	sort.Strings(report.Missing)
+	sort.Strings(report.MissingOptional)
+	return report, nil
 }
 
 // ForEachDependency runs function func for each dependency
@@ -51,11 +95,10 @@ func (cfg *PkgConfig) CheckDependencies(root *Root) (bool, error) {
 // If inner function returns error, loop stops and function returns this error
 func (cfg *PkgConfig) ForEachDependency(inner func(string, string, bool) error) error {
 	for id, isRequired := range cfg.Dependencies {
-		var name, version string
 		// Parsing ID
-		_, err := fmt.Sscanf(id, "%s-$%s", &name, &version)
+		name, version, err := ParseID(id)
 		if err != nil {
-			return fmt.Errorf("parsing id %s: %v", id, err)
+			return err
 		}
 		err = inner(name, version, isRequired)
 		if err != nil {
@@ -65,6 +108,21 @@ func (cfg *PkgConfig) ForEachDependency(inner func(string, string, bool) error)
 	return nil
 }
 
+// PackageID returns ID of package used in dependency lists and as name of installation folder.
+// Format: name-$version
+func PackageID(name, version string) string {
+	return name + "-$" + version
+}
+
+// ParseID splits package ID made by PackageID into name and version
+func ParseID(id string) (name string, version string, err error) {
+	sep := strings.LastIndex(id, "-$")
+	if sep <= 0 || sep+2 == len(id) {
+		return "", "", fmt.Errorf("parsing id %s: expected format name-$version", id)
+	}
+	return id[:sep], id[sep+2:], nil
+}
+
 // SerializeDependencies prepares package dependencies for saving in database
 // by saving them in one string. Format: dependencyID1(flag1);dependencyID2(flag2);...;dependencyIDN(flagN)
 // Flag specifies is package required (!) or not (?)
@@ -0,0 +1,205 @@
+package ipkg
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	osextra "github.com/ira-package-manager/gobetter/os_extra"
+)
+
+// Configuration files are listed in ConfigFiles of package config. Their hashes recorded on installation
+// are hashes of pristine files, so edits of user are found by comparing them with files on disk.
+// When new version is installed, edited files of active version are copied into it and new files
+// are kept next to them with NewConfigSuffix. When package is removed, edited files are saved
+// in <root>/config/<name> and restored on next installation, unless package is purged.
+
+// NewConfigSuffix is added to configuration file of new version, if user's file is kept instead of it
+const NewConfigSuffix = ".ipkgnew"
+
+// savedConfigDir returns folder where edited configuration files of removed package name are saved
+func (r *Root) savedConfigDir(name string) string {
+	return filepath.Join(r.path, "config", name)
+}
+
+// keepConfigFiles replaces configuration files of just installed package name-$version with files edited by user.
+// Edited files are taken from active version of package or from configuration saved when package was removed
+func (r *Root) keepConfigFiles(name, version string) error {
+	files, err := r.Files(name, version)
+	if err != nil {
+		return err
+	}
+	installDir, err := filepath.Abs(filepath.Join(r.path, PackageID(name, version)))
+	if err != nil {
+		return err
+	}
+	// edited returns file of user which must be kept instead of configuration file rel or empty string
+	var edited func(rel string) string
+	var previous string
+	err = r.db.QueryRow("SELECT version FROM packages WHERE name = ? AND active = 1 AND version != ?", name, version).Scan(&previous)
+	if err == nil {
+		previousDir, err := filepath.Abs(filepath.Join(r.path, PackageID(name, previous)))
+		if err != nil {
+			return err
+		}
+		previousFiles, err := r.Files(name, previous)
+		if err != nil {
+			return err
+		}
+		recorded := make(map[string]OwnedFile)
+		for _, file := range previousFiles {
+			recorded[file.Path] = file
+		}
+		edited = func(rel string) string {
+			path := filepath.Join(previousDir, rel)
+			file, ok := recorded[path]
+			if !ok || file.Type != FileRegular {
+				return ""
+			}
+			if issue := verifyFile(file); issue == nil || issue.Problem == ProblemMissing {
+				return ""
+			}
+			return path
+		}
+	} else if err == sql.ErrNoRows {
+		saved := r.savedConfigDir(name)
+		edited = func(rel string) string {
+			path := filepath.Join(saved, rel)
+			if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+				return ""
+			}
+			return path
+		}
+		defer os.RemoveAll(saved)
+	} else {
+		return fmt.Errorf("getting active version of %s: %v", name, err)
+	}
+	logger := r.pkgLogger(name, version)
+	for _, file := range files {
+		if !file.Config || file.Type != FileRegular {
+			continue
+		}
+		rel, err := filepath.Rel(installDir, file.Path)
+		if err != nil {
+			return err
+		}
+		source := edited(rel)
+		if source == "" {
+			continue
+		}
+		kept, err := keepConfigFile(source, file)
+		if err != nil {
+			return fmt.Errorf("keeping configuration file %s: %w", file.Path, err)
+		}
+		if kept {
+			logger.Info("configuration file kept", "path", file.Path, "new", file.Path+NewConfigSuffix)
+		}
+	}
+	return nil
+}
+
+// keepConfigFile moves pristine configuration file to file.Path+NewConfigSuffix and puts edited file from source instead.
+// Edited file is copied next to configuration file first, so file.Path is never missing or partially written.
+// If edited file is the same as new one, nothing is done and false is returned
+func keepConfigFile(source string, file OwnedFile) (bool, error) {
+	edited, err := describeFile(source)
+	if err != nil {
+		return false, err
+	}
+	if edited.SHA256 == file.SHA256 {
+		return false, nil
+	}
+	temp, err := os.CreateTemp(filepath.Dir(file.Path), "."+filepath.Base(file.Path)+"*")
+	if err != nil {
+		return false, err
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+	if err = osextra.Copy(source, temp.Name()); err != nil {
+		return false, err
+	}
+	if err = os.Chmod(temp.Name(), edited.Mode); err != nil {
+		return false, err
+	}
+	if err = os.Rename(file.Path, file.Path+NewConfigSuffix); err != nil {
+		return false, err
+	}
+	if err = os.Rename(temp.Name(), file.Path); err != nil {
+		os.Rename(file.Path+NewConfigSuffix, file.Path)
+		return false, err
+	}
+	return true, nil
+}
+
+// saveConfigFiles saves configuration files of package name-$version edited by user, so they are restored
+// when package is installed again. Edited file isn't saved if another installed version keeps the same file.
+// If purge is true, configuration isn't saved and previously saved one is removed
+func (r *Root) saveConfigFiles(name, version string, purge bool) error {
+	saved := r.savedConfigDir(name)
+	if purge {
+		if err := os.RemoveAll(saved); err != nil {
+			return fmt.Errorf("removing saved configuration: %w", err)
+		}
+		return nil
+	}
+	pkgs, err := r.FindPackagesByName(name)
+	if err != nil {
+		return err
+	}
+	var others []string
+	for _, pkg := range pkgs {
+		if pkg.Version != version {
+			others = append(others, filepath.Join(r.path, PackageID(name, pkg.Version)))
+		}
+	}
+	// kept checks if another installed version has file rel with the same content
+	kept := func(rel, sha256 string) bool {
+		for _, other := range others {
+			if file, err := describeFile(filepath.Join(other, rel)); err == nil && file.Type == FileRegular && file.SHA256 == sha256 {
+				return true
+			}
+		}
+		return false
+	}
+	files, err := r.Files(name, version)
+	if err != nil {
+		return err
+	}
+	installDir, err := filepath.Abs(filepath.Join(r.path, PackageID(name, version)))
+	if err != nil {
+		return err
+	}
+	logger := r.pkgLogger(name, version)
+	for _, file := range files {
+		if !file.Config || file.Type != FileRegular {
+			continue
+		}
+		if issue := verifyFile(file); issue == nil || issue.Problem == ProblemMissing {
+			continue
+		}
+		rel, err := filepath.Rel(installDir, file.Path)
+		if err != nil {
+			return err
+		}
+		edited, err := describeFile(file.Path)
+		if err != nil {
+			return fmt.Errorf("saving configuration file %s: %w", file.Path, err)
+		}
+		if kept(rel, edited.SHA256) {
+			continue
+		}
+		path := filepath.Join(saved, rel)
+		if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return fmt.Errorf("saving configuration file %s: %w", file.Path, err)
+		}
+		if err = osextra.Copy(file.Path, path); err != nil {
+			return fmt.Errorf("saving configuration file %s: %w", file.Path, err)
+		}
+		if err = os.Chmod(path, edited.Mode); err != nil {
+			return fmt.Errorf("saving configuration file %s: %w", file.Path, err)
+		}
+		logger.Info("configuration file saved", "path", file.Path, "saved", path)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package ipkg_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+// writeConfigFiles marks files of package tool-$version in path as configuration files
+func writeConfigFiles(t *testing.T, path, version string, files ...string) {
+	t.Helper()
+	config, err := json.Marshal(ipkg.PkgConfig{Name: "tool", Version: version, SupportWindows: true, SupportLinux: true, ConfigFiles: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(path, ".ira", "config.json"), config, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	install := func(version string) {
+		t.Helper()
+		pkg := writeActivatedPackage(t, dir, links, version, "tool", "main.ini")
+		writeConfigFiles(t, pkg, version, "main.ini")
+		if err := root.InstallPackage(pkg, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// check verifies content of files in installation folder of tool-$version. Empty content means no file
+	check := func(version string, files map[string]string) {
+		t.Helper()
+		for file, expected := range files {
+			content, err := os.ReadFile(filepath.Join(path, ipkg.PackageID("tool", version), file))
+			if expected == "" && !os.IsNotExist(err) {
+				t.Errorf("%s of tool-$%s exists: %v", file, version, err)
+			} else if expected != "" && string(content) != expected {
+				t.Errorf("wrong %s of tool-$%s: got %q, expected %q (%v)", file, version, content, expected, err)
+			}
+		}
+	}
+
+	install("1.0")
+	if err = os.WriteFile(filepath.Join(path, ipkg.PackageID("tool", "1.0"), "main.ini"), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Upgrade keeps edited file and puts new one next to it
+	install("2.0")
+	check("2.0", map[string]string{"tool": "2.0", "main.ini": "edited", "main.ini" + ipkg.NewConfigSuffix: "2.0"})
+	report, err := root.Verify("tool", "2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Problem != ipkg.ProblemConfigEdited {
+		t.Errorf("wrong problems of upgraded package: %+v", report.Issues)
+	}
+	if report.Drift() {
+		t.Error("edited configuration file is reported as drift")
+	}
+	files, err := root.Files("tool", "2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.Config != (filepath.Base(file.Path) == "main.ini" && file.Type == ipkg.FileRegular) {
+			t.Errorf("wrong configuration flag of %s: %v", file.Path, file.Config)
+		}
+	}
+
+	// Edited configuration of the last version is saved on removing and restored on installation
+	for _, version := range []string{"1.0", "2.0"} {
+		if err = root.RemovePackage("tool", version, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	install("2.0")
+	check("2.0", map[string]string{"main.ini": "edited", "main.ini" + ipkg.NewConfigSuffix: "2.0"})
+	err = root.RemovePackageWithOptions("tool", "2.0", ipkg.RemoveOptions{Purge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	install("2.0")
+	check("2.0", map[string]string{"main.ini": "2.0", "main.ini" + ipkg.NewConfigSuffix: ""})
+}
+
+func TestSaveConfigFilesWithInactiveVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	for _, version := range []string{"1.0", "2.0"} {
+		pkg := writeActivatedPackage(t, dir, links, version, "tool", "main.ini")
+		writeConfigFiles(t, pkg, version, "main.ini")
+		if err = root.InstallPackage(pkg, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Inactive version keeps pristine file, so edited file of removed version is saved
+	if err = os.WriteFile(filepath.Join(path, ipkg.PackageID("tool", "2.0"), "main.ini"), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{"2.0", "1.0"} {
+		if err = root.RemovePackage("tool", version, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg := writeActivatedPackage(t, dir, links, "2.0", "tool", "main.ini")
+	writeConfigFiles(t, pkg, "2.0", "main.ini")
+	if err = root.InstallPackage(pkg, false); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(path, ipkg.PackageID("tool", "2.0"), "main.ini")); err != nil || string(content) != "edited" {
+		t.Errorf("edited configuration isn't restored: %q (%v)", content, err)
+	}
+}
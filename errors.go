@@ -0,0 +1,35 @@
+package ipkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by package operations. They are wrapped, so errors.Is must be used to check them
+var (
+	ErrAlreadyInstalled    = errors.New("already installed")
+	ErrNotInstalled        = errors.New("not installed")
+	ErrUnsupportedPlatform = errors.New("unsupported platform")
+	ErrNotAPackage         = errors.New("not an IRA package")
+	ErrNotOwned            = errors.New("not owned by any package")
+)
+
+// MissingDependenciesError is returned when package can't be installed because its required dependencies aren't installed
+type MissingDependenciesError struct {
+	Name    string
+	Version string
+	Missing []string          // IDs of missing dependencies
+	Report  *DependencyReport // full report including optional dependencies and rejected versions
+}
+
+func (e *MissingDependenciesError) Error() string {
+	missing := make([]string, len(e.Missing))
+	for i, id := range e.Missing {
+		missing[i] = id
+		if e.Report != nil && len(e.Report.Rejected[id]) != 0 {
+			missing[i] += " (installed versions: " + strings.Join(e.Report.Rejected[id], ", ") + ")"
+		}
+	}
+	return fmt.Sprintf("package %s requires %s which are not installed", PackageID(e.Name, e.Version), strings.Join(missing, ", "))
+}
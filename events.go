@@ -0,0 +1,118 @@
+package ipkg
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Phase is a part of package operation reported to observers
+type Phase string
+
+// Phases of package operations
+const (
+	PhaseExtract  Phase = "extract"  // unpacking IPKG archive
+	PhaseBuild    Phase = "build"    // running build script
+	PhaseIScript  Phase = "iscript"  // running IScript (installing or removing files)
+	PhaseDatabase Phase = "db"       // adding package in database or removing from it
+	PhaseActivate Phase = "activate" // activating package or deactivating it
+)
+
+// EventType describes what happened
+type EventType string
+
+// Types of events
+const (
+	EventPhaseStarted   EventType = "phase-started"
+	EventPhaseFinished  EventType = "phase-finished"
+	EventBytesExtracted EventType = "bytes-extracted" // Bytes of Total bytes of archive were extracted
+	EventFileInstalled  EventType = "file-installed"  // File was installed by IScript
+	EventLinkSkipped    EventType = "link-skipped"    // activation link File wasn't removed, Detail tells why
+)
+
+// Event is sent to observers during package operations
+type Event struct {
+	Type      EventType `json:"type"`
+	Operation string    `json:"operation"` // OpInstall, OpRemove or OpActivate
+	// Package which operation works with. Name and version are unknown while archive is extracted
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+	Phase   Phase  `json:"phase,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`  // extracted bytes
+	Total   int64  `json:"total,omitempty"`  // size of all files in archive
+	File    string `json:"file,omitempty"`   // path to archive while extracting, installed file relative to installation folder or skipped link
+	Detail  string `json:"detail,omitempty"` // reason why link was skipped
+	Err     error  `json:"-"`                // error of finished phase, nil if phase succeeded, serialized as its text
+}
+
+// eventJSON is Event with text of its error, so failed phases can be serialized
+type eventJSON struct {
+	event
+	Error string `json:"error,omitempty"`
+}
+
+// event has the same fields as Event without its methods
+type event Event
+
+// MarshalJSON serializes event with text of Err as "error"
+func (e Event) MarshalJSON() ([]byte, error) {
+	data := eventJSON{event: event(e)}
+	if e.Err != nil {
+		data.Error = e.Err.Error()
+	}
+	return json.Marshal(data)
+}
+
+// UnmarshalJSON restores event serialized by MarshalJSON. Err only keeps text of original error
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var data eventJSON
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	*e = Event(data.event)
+	if data.Error != "" {
+		e.Err = errors.New(data.Error)
+	}
+	return nil
+}
+
+// Observer receives events of package operations. Events are sent synchronously from goroutine
+// running the operation, so observer must be fast and safe for concurrent use if Root is used concurrently
+type Observer interface {
+	OnEvent(Event)
+}
+
+// ObserverFunc allows using ordinary function as Observer
+type ObserverFunc func(Event)
+
+// OnEvent calls f(event)
+func (f ObserverFunc) OnEvent(event Event) { f(event) }
+
+// WithObserver subscribes observer to events of package operations. Several observers can be subscribed
+func WithObserver(observer Observer) Option {
+	return func(r *Root) {
+		r.observers = append(r.observers, observer)
+	}
+}
+
+// emit sends event to all observers
+func (r *Root) emit(event Event) {
+	for _, observer := range r.observers {
+		observer.OnEvent(event)
+	}
+}
+
+// startPhase reports to observers and logger that phase of operation on package name-$version started.
+// Returned function must be called with result of phase when it finishes
+func (r *Root) startPhase(operation, name, version string, phase Phase) func(error) {
+	logger := r.pkgLogger(name, version).With("operation", operation, "phase", phase)
+	logger.Debug("phase started")
+	r.emit(Event{Type: EventPhaseStarted, Operation: operation, Name: name, Version: version, Phase: phase})
+	return func(err error) {
+		if err != nil {
+			logger.Error("phase failed", "error", err)
+		} else {
+			logger.Debug("phase finished")
+		}
+		r.emit(Event{Type: EventPhaseFinished, Operation: operation, Name: name, Version: version, Phase: phase, Err: err})
+	}
+}
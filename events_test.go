@@ -0,0 +1,98 @@
+package ipkg_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestInstallEvents(t *testing.T) {
+	var mu sync.Mutex
+	var events []ipkg.Event
+	observer := ipkg.ObserverFunc(func(event ipkg.Event) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	})
+	root, err := ipkg.CreateRoot(t.TempDir(), ipkg.WithObserver(observer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err = root.InstallPackage("./test/pkgs/testpkg.ipkg", false); err != nil {
+		t.Fatal(err)
+	}
+	var phases []ipkg.Phase
+	var lastExtracted ipkg.Event
+	files := make(map[string]bool)
+	for _, event := range events {
+		switch event.Type {
+		case ipkg.EventPhaseStarted:
+			phases = append(phases, event.Phase)
+		case ipkg.EventPhaseFinished:
+			if event.Err != nil {
+				t.Errorf("phase %s failed: %v", event.Phase, event.Err)
+			}
+		case ipkg.EventBytesExtracted:
+			lastExtracted = event
+		case ipkg.EventFileInstalled:
+			files[filepath.ToSlash(event.File)] = true
+		}
+	}
+	expected := []ipkg.Phase{ipkg.PhaseExtract, ipkg.PhaseBuild, ipkg.PhaseIScript, ipkg.PhaseDatabase, ipkg.PhaseActivate}
+	if len(phases) != len(expected) {
+		t.Fatalf("wrong phases: got %v, expected %v", phases, expected)
+	}
+	for i := range expected {
+		if phases[i] != expected[i] {
+			t.Fatalf("wrong phases: got %v, expected %v", phases, expected)
+		}
+	}
+	if lastExtracted.Total == 0 || lastExtracted.Bytes != lastExtracted.Total {
+		t.Errorf("extracted %d bytes of %d", lastExtracted.Bytes, lastExtracted.Total)
+	}
+	if !files["scripts/run.sh"] || !files["cfg/main.ini"] {
+		t.Errorf("installed files weren't reported: %v", files)
+	}
+}
+
+func TestFailedPhaseEvent(t *testing.T) {
+	var finished []ipkg.Event
+	observer := ipkg.ObserverFunc(func(event ipkg.Event) {
+		if event.Type == ipkg.EventPhaseFinished {
+			finished = append(finished, event)
+		}
+	})
+	root, err := ipkg.CreateRoot(t.TempDir(), ipkg.WithObserver(observer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	archive := filepath.Join(t.TempDir(), "broken.ipkg")
+	if err = os.WriteFile(archive, []byte("not an archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage(archive, false); err == nil {
+		t.Fatal("broken archive was installed")
+	}
+	if len(finished) != 1 || finished[0].Err == nil {
+		t.Fatalf("failed phase wasn't reported: %+v", finished)
+	}
+	// Error is kept when event is serialized
+	data, err := json.Marshal(finished[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ipkg.Event
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Err == nil || decoded.Err.Error() != finished[0].Err.Error() || !strings.Contains(string(data), `"error":`) {
+		t.Errorf("error isn't serialized: %s", data)
+	}
+}
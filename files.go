@@ -0,0 +1,285 @@
+package ipkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Types of files owned by packages
+const (
+	FileRegular    = "file"       // regular file installed by IScript
+	FileDirectory  = "dir"        // directory created by IScript
+	FileSymlink    = "symlink"    // symbolic link installed by IScript
+	FileActivation = "activation" // activation link created when package is activated
+)
+
+// OwnedFile is a file put on disk by package
+type OwnedFile struct {
+	Path string      // absolute path
+	Type string      // FileRegular, FileDirectory, FileSymlink or FileActivation
+	Mode fs.FileMode // permissions, zero for activation links
+	// SHA-256 of content of regular file or target of symbolic link, empty for directories and activation links.
+	// For configuration files it is hash of pristine file installed by package
+	SHA256 string
+	Config bool // true if file is a configuration file, which can be edited by user
+}
+
+// collectFiles returns all files installed in installDir and activation links of package
+func collectFiles(installDir string) ([]OwnedFile, error) {
+	abs, err := filepath.Abs(installDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []OwnedFile
+	err = filepath.WalkDir(abs, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == abs {
+			return nil
+		}
+		if entry.IsDir() && entry.Name() == ".ira" && filepath.Dir(path) == abs {
+			return filepath.SkipDir // package metadata isn't installed by IScript
+		}
+		file, err := describeFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, *file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing installed files: %w", err)
+	}
+	links, _, err := readActivationLog(installDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		files = append(files, OwnedFile{Path: link.Link, Type: FileActivation})
+	}
+	return files, nil
+}
+
+// describeFile returns type, mode and hash of file in path
+func describeFile(path string) (*OwnedFile, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &OwnedFile{Path: path, Mode: info.Mode().Perm()}
+	switch {
+	case info.IsDir():
+		file.Type = FileDirectory
+	case info.Mode()&fs.ModeSymlink != 0:
+		file.Type = FileSymlink
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256([]byte(target))
+		file.SHA256 = hex.EncodeToString(sum[:])
+	default:
+		file.Type = FileRegular
+		content, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer content.Close()
+		hash := sha256.New()
+		if _, err = io.Copy(hash, content); err != nil {
+			return nil, err
+		}
+		file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	}
+	return file, nil
+}
+
+// recordFiles saves files of package with database ID packageID
+func recordFiles(ctx context.Context, tx *sql.Tx, packageID int64, files []OwnedFile) error {
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO files (package_id, path, type, mode, sha256) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, file := range files {
+		if _, err = stmt.ExecContext(ctx, packageID, file.Path, file.Type, uint32(file.Mode), file.SHA256); err != nil {
+			return fmt.Errorf("recording file %s: %v", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// markConfigFiles marks files of package with database ID packageID installed in installDir as configuration files.
+// paths are slash separated and relative to installDir
+func markConfigFiles(ctx context.Context, tx *sql.Tx, packageID int64, installDir string, paths []string) error {
+	abs, err := filepath.Abs(installDir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		target, ok := validTarget(path)
+		if !ok {
+			return fmt.Errorf("configuration file %q is outside of package", path)
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE files SET config = 1 WHERE package_id = ? AND path = ?",
+			packageID, filepath.Join(abs, filepath.FromSlash(target)))
+		if err != nil {
+			return fmt.Errorf("marking configuration file %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// Files returns all files owned by package name-$version
+func (r *Root) Files(name, version string) ([]OwnedFile, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	rows, err := r.db.Query(`SELECT files.path, files.type, files.mode, files.sha256, files.config FROM files
+		JOIN packages ON packages.id = files.package_id
+		WHERE packages.name = ? AND packages.version = ? ORDER BY files.path`, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("in Files: %v", err)
+	}
+	defer rows.Close()
+	var files []OwnedFile
+	for rows.Next() {
+		var file OwnedFile
+		var mode uint32
+		if err = rows.Scan(&file.Path, &file.Type, &mode, &file.SHA256, &file.Config); err != nil {
+			return nil, fmt.Errorf("in Files: %v", err)
+		}
+		file.Mode = fs.FileMode(mode)
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// FileOwner returns package which put file in path on disk. Activation link is owned by active version,
+// if several versions of package declare it. If path isn't owned by any package, returns ErrNotOwned
+func (r *Root) FileOwner(path string) (*PkgConfig, *OwnedFile, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, nil, err
+	}
+	defer r.lock.unlock(false)
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := r.db.Query(`SELECT packages.name, packages.version, files.type, files.mode, files.sha256, files.config FROM files
+		JOIN packages ON packages.id = files.package_id
+		WHERE files.path = ? ORDER BY packages.active DESC, packages.id DESC`, path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("in FileOwner: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, nil, fmt.Errorf("in FileOwner: %v", err)
+		}
+		return nil, nil, fmt.Errorf("%s is %w", path, ErrNotOwned)
+	}
+	pkg := new(PkgConfig)
+	file := &OwnedFile{Path: path}
+	var mode uint32
+	if err = rows.Scan(&pkg.Name, &pkg.Version, &file.Type, &mode, &file.SHA256, &file.Config); err != nil {
+		return nil, nil, fmt.Errorf("in FileOwner: %v", err)
+	}
+	file.Mode = fs.FileMode(mode)
+	return pkg, file, nil
+}
+
+// migrateFiles creates table of files owned by packages and fills it with files of installed packages
+func migrateFiles(r *Root, tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE files (
+		package_id INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		type TEXT NOT NULL,
+		mode INTEGER NOT NULL DEFAULT (0),
+		sha256 TEXT NOT NULL DEFAULT ('')
+	);
+	CREATE INDEX files_path ON files (path);
+	CREATE INDEX files_package_id ON files (package_id);`)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT id, name, version FROM packages")
+	if err != nil {
+		return err
+	}
+	ids := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var name, version string
+		if err = rows.Scan(&id, &name, &version); err != nil {
+			rows.Close()
+			return err
+		}
+		ids[id] = PackageID(name, version)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, pkgID := range ids {
+		installDir := filepath.Join(r.path, pkgID)
+		if _, err = os.Stat(installDir); os.IsNotExist(err) {
+			continue
+		}
+		files, err := collectFiles(installDir)
+		if err != nil {
+			return fmt.Errorf("files of %s: %w", pkgID, err)
+		}
+		if err = recordFiles(context.Background(), tx, id, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateConfigFiles adds flag of configuration files. Files of packages are marked if their cached config lists them
+func migrateConfigFiles(r *Root, tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE files ADD COLUMN config INTEGER NOT NULL DEFAULT (0)"); err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT id, name, version FROM packages")
+	if err != nil {
+		return err
+	}
+	pkgs := make(map[int64]PkgConfig)
+	for rows.Next() {
+		var id int64
+		var pkg PkgConfig
+		if err = rows.Scan(&id, &pkg.Name, &pkg.Version); err != nil {
+			rows.Close()
+			return err
+		}
+		pkgs[id] = pkg
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, pkg := range pkgs {
+		config, err := ParseConfig(filepath.Join(r.cacheDir(pkg.Name, pkg.Version), ".ira", "config.json"))
+		if os.IsNotExist(err) {
+			continue // packages installed before caching have no known configuration files
+		} else if err != nil {
+			return fmt.Errorf("config of %s: %w", PackageID(pkg.Name, pkg.Version), err)
+		}
+		installDir := filepath.Join(r.path, PackageID(pkg.Name, pkg.Version))
+		if err = markConfigFiles(context.Background(), tx, id, installDir, config.ConfigFiles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package ipkg_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestFileOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	if err = root.InstallPackage(writeActivatedPackage(t, dir, links, "1.0", "tool"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage(writeActivatedPackage(t, dir, links, "2.0", "tool"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := root.Files("tool", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	installed := filepath.Join(path, ipkg.PackageID("tool", "1.0"), "tool")
+	if len(files) != 2 || files[0].Path != installed || files[0].Type != ipkg.FileRegular || files[0].SHA256 == "" {
+		t.Errorf("wrong files: %+v", files)
+	}
+	pkg, file, err := root.FileOwner(installed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(installed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Name != "tool" || pkg.Version != "1.0" || file.Mode != info.Mode().Perm() {
+		t.Errorf("wrong owner of %s: %s-$%s (%+v)", installed, pkg.Name, pkg.Version, file)
+	}
+	// Activation link is owned by active version
+	pkg, file, err = root.FileOwner(filepath.Join(links, "tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Version != "2.0" || file.Type != ipkg.FileActivation {
+		t.Errorf("wrong owner of activation link: %s-$%s (%+v)", pkg.Name, pkg.Version, file)
+	}
+	if _, _, err = root.FileOwner(os.TempDir()); !errors.Is(err, ipkg.ErrNotOwned) {
+		t.Errorf("expected ErrNotOwned, got %v", err)
+	}
+	if err = root.RemovePackage("tool", "1.0", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = root.FileOwner(installed); !errors.Is(err, ipkg.ErrNotOwned) {
+		t.Errorf("files of removed package are still owned: %v", err)
+	}
+}
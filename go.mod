@@ -9,10 +9,10 @@ require (
 	github.com/ira-package-manager/gobetter v0.0.0-20230910093258-42b7f2b33da3
 	github.com/ira-package-manager/iscript v0.0.0-20230903081757-70166a1340c3
 	golang.org/x/mod v0.12.0
+	golang.org/x/sys v0.6.0
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
-	golang.org/x/sys v0.6.0 // indirect
 )
@@ -0,0 +1,219 @@
+package ipkg
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GraphNode is a package in dependency graph
+type GraphNode struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	ByUser  bool   `json:"by_user"` // package was installed by user, not as dependency
+	Active  bool   `json:"active"`
+	Missing bool   `json:"missing"` // dependency isn't installed
+}
+
+// GraphEdge means package From depends on package To
+type GraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Optional bool   `json:"optional"`
+}
+
+// Graph is a dependency graph of packages in root. Nodes and edges are sorted by IDs
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// DependencyGraph returns dependency graph of all packages in root.
+// If name isn't empty, graph contains only packages named name (all versions) and their dependencies
+func (r *Root) DependencyGraph(name string) (*Graph, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	rows, err := r.db.Query("SELECT name, version, dependencies, by_user FROM packages")
+	if err != nil {
+		return nil, fmt.Errorf("in DependencyGraph: %v", err)
+	}
+	defer rows.Close()
+	installed := make(map[string]PkgConfig)
+	nodes := make(map[string]GraphNode)
+	for rows.Next() {
+		var cfg PkgConfig
+		var dependencies string
+		var byUser int
+		if err = rows.Scan(&cfg.Name, &cfg.Version, &dependencies, &byUser); err != nil {
+			return nil, fmt.Errorf("in DependencyGraph: %v", err)
+		}
+		cfg.Dependencies = UnserializeDependencies(dependencies)
+		id := PackageID(cfg.Name, cfg.Version)
+		installed[id] = cfg
+		nodes[id] = GraphNode{ID: id, Name: cfg.Name, Version: cfg.Version, ByUser: byUser == 1}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("in DependencyGraph: %v", err)
+	}
+	rows.Close()
+
+	// Choosing packages which graph starts from
+	var queue []string
+	for id, cfg := range installed {
+		if name == "" || cfg.Name == name {
+			queue = append(queue, id)
+		}
+	}
+	if name != "" && len(queue) == 0 {
+		return nil, fmt.Errorf("package %s is %w", name, ErrNotInstalled)
+	}
+	// Walking dependencies
+	graph := &Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	visited := make(map[string]bool)
+	for len(queue) != 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		node, ok := nodes[id]
+		if !ok {
+			depName, depVersion, err := ParseID(id)
+			if err != nil {
+				return nil, err
+			}
+			graph.Nodes = append(graph.Nodes, GraphNode{ID: id, Name: depName, Version: depVersion, Missing: true})
+			continue
+		}
+		node.Active = r.IsActive(node.Name, node.Version)
+		graph.Nodes = append(graph.Nodes, node)
+		for dep, isRequired := range installed[id].Dependencies {
+			graph.Edges = append(graph.Edges, GraphEdge{From: id, To: dep, Optional: !isRequired})
+			queue = append(queue, dep)
+		}
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].ID < graph.Nodes[j].ID })
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph, nil
+}
+
+// WriteDOT writes graph in Graphviz DOT format. Packages installed by user are boxes,
+// active packages are filled, missing dependencies and optional edges are dashed
+func (g *Graph) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph ipkg {"); err != nil {
+		return err
+	}
+	for _, node := range g.Nodes {
+		shape := "ellipse"
+		if node.ByUser {
+			shape = "box"
+		}
+		style := ""
+		if node.Missing {
+			style = "dashed"
+		} else if node.Active {
+			style = "filled"
+		}
+		if _, err := fmt.Fprintf(w, "\t%q [shape=%s, style=%q];\n", node.ID, shape, style); err != nil {
+			return err
+		}
+	}
+	for _, edge := range g.Edges {
+		style := "solid"
+		if edge.Optional {
+			style = "dashed"
+		}
+		if _, err := fmt.Fprintf(w, "\t%q -> %q [style=%s];\n", edge.From, edge.To, style); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// Why explains why package name-$version is installed. It returns chains of package IDs from packages
+// installed by user to package name-$version through required dependencies: the shortest chain for every
+// package directly requiring it, so the number of chains doesn't grow with the number of all paths.
+// If package was installed by user, one chain contains only it.
+// If package is a dependency and no chains are returned, package is orphaned
+func (r *Root) Why(name, version string) ([][]string, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	if _, err := r.IsDependency(name, version); err == sql.ErrNoRows {
+		return nil, fmt.Errorf("package %s-$%s is %w", name, version, ErrNotInstalled)
+	} else if err != nil {
+		return nil, fmt.Errorf("in Why: %w", err)
+	}
+	graph, err := r.DependencyGraph("")
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		byUser[node.ID] = node.ByUser
+	}
+	// Optional dependencies can be removed without their dependents, so they don't keep package installed
+	dependents := make(map[string][]string)
+	for _, edge := range graph.Edges {
+		if !edge.Optional {
+			dependents[edge.To] = append(dependents[edge.To], edge.From)
+		}
+	}
+	for _, from := range dependents {
+		sort.Strings(from)
+	}
+	id := PackageID(name, version)
+	var chains [][]string
+	if byUser[id] {
+		chains = append(chains, []string{id})
+	}
+	for _, dependent := range dependents[id] {
+		if chain := shortestChain(id, dependent, dependents, byUser); chain != nil {
+			chains = append(chains, chain)
+		}
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		return strings.Join(chains[i], " ") < strings.Join(chains[j], " ")
+	})
+	return chains, nil
+}
+
+// shortestChain searches the nearest package installed by user which requires package id through its dependent.
+// Returns chain from found package to id or nil if there is no such package
+func shortestChain(id, dependent string, dependents map[string][]string, byUser map[string]bool) []string {
+	// next keeps package of the chain which leads from key to id
+	next := map[string]string{dependent: id}
+	queue := []string{dependent}
+	for len(queue) != 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if byUser[current] {
+			chain := []string{current}
+			for current != id {
+				current = next[current]
+				chain = append(chain, current)
+			}
+			return chain
+		}
+		for _, from := range dependents[current] {
+			if _, ok := next[from]; !ok && from != id {
+				next[from] = current
+				queue = append(queue, from)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package ipkg_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir := t.TempDir()
+	if err = root.InstallPackage(writePackage(t, dir, "lib", "1.0", nil), true); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage(writePackage(t, dir, "app", "1.0", map[string]bool{"lib-$1.0": true, "extra-$1.0": false}), false); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage(writePackage(t, dir, "other", "1.0", nil), false); err != nil {
+		t.Fatal(err)
+	}
+
+	graph, err := root.DependencyGraph("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != 4 || len(graph.Edges) != 2 {
+		t.Fatalf("wrong graph: %+v", graph)
+	}
+	expected := []ipkg.GraphNode{
+		{ID: "app-$1.0", Name: "app", Version: "1.0", ByUser: true, Active: true},
+		{ID: "extra-$1.0", Name: "extra", Version: "1.0", Missing: true},
+		{ID: "lib-$1.0", Name: "lib", Version: "1.0", Active: true},
+		{ID: "other-$1.0", Name: "other", Version: "1.0", ByUser: true, Active: true},
+	}
+	for i, node := range expected {
+		if graph.Nodes[i] != node {
+			t.Errorf("wrong node: got %+v, expected %+v", graph.Nodes[i], node)
+		}
+	}
+	if edge := graph.Edges[0]; edge.To != "extra-$1.0" || !edge.Optional {
+		t.Errorf("wrong optional edge: %+v", edge)
+	}
+	if edge := graph.Edges[1]; edge.From != "app-$1.0" || edge.To != "lib-$1.0" || edge.Optional {
+		t.Errorf("wrong required edge: %+v", edge)
+	}
+
+	// Graph rooted at one package
+	graph, err = root.DependencyGraph("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != 3 {
+		t.Errorf("graph of app contains unrelated packages: %+v", graph.Nodes)
+	}
+	var dot bytes.Buffer
+	if err = graph.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dot.String(), `"app-$1.0" -> "extra-$1.0" [style=dashed];`) {
+		t.Errorf("optional edge isn't dashed:\n%s", dot.String())
+	}
+	if _, err = root.DependencyGraph("unknown"); !errors.Is(err, ipkg.ErrNotInstalled) {
+		t.Errorf("expected ErrNotInstalled, got %v", err)
+	}
+}
+
+func TestWhy(t *testing.T) {
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir := t.TempDir()
+	packages := []struct {
+		name         string
+		dependencies map[string]bool
+		asDependency bool
+	}{
+		{"base", nil, true},
+		{"lib", map[string]bool{"base-$1.0": true}, true},
+		{"orphan", nil, true},
+		{"tool", map[string]bool{"base-$1.0": true}, false},
+		{"app", map[string]bool{"lib-$1.0": true, "tool-$1.0": false}, false},
+		{"suite", map[string]bool{"app-$1.0": true, "lib-$1.0": true}, false},
+	}
+	for _, pkg := range packages {
+		if err = root.InstallPackage(writePackage(t, dir, pkg.name, "1.0", pkg.dependencies), pkg.asDependency); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chains, err := root.Why("base", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Optional dependency of app doesn't explain base and only the shortest chain through lib is returned
+	expected := []string{
+		"app-$1.0 lib-$1.0 base-$1.0",
+		"tool-$1.0 base-$1.0",
+	}
+	if len(chains) != len(expected) {
+		t.Fatalf("expected %d chains, got %v", len(expected), chains)
+	}
+	for i, chain := range chains {
+		if strings.Join(chain, " ") != expected[i] {
+			t.Errorf("wrong chain: got %v, expected %s", chain, expected[i])
+		}
+	}
+	if chains, err = root.Why("orphan", "1.0"); err != nil || len(chains) != 0 {
+		t.Errorf("orphaned package has chains %v (%v)", chains, err)
+	}
+	if _, err = root.Why("unknown", "1.0"); !errors.Is(err, ipkg.ErrNotInstalled) {
+		t.Errorf("expected ErrNotInstalled, got %v", err)
+	}
+}
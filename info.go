@@ -0,0 +1,82 @@
+package ipkg
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PackageInfo describes installed package
+type PackageInfo struct {
+	PkgConfig
+	Active       bool
+	ActivatedAt  time.Time   // last activation, zero if package was never activated or time is unknown
+	AsDependency bool        // true if package was installed as dependency
+	Dependents   []PkgConfig // packages requiring this package
+	// Optional reports which optional dependencies (features) were enabled, i.e. installed, when package was installed.
+	// For packages installed by older ipkg currently installed ones are reported. Keys are IDs of dependencies
+	Optional map[string]bool
+}
+
+// EnabledOptional returns sorted IDs of installed optional dependencies
+func (info *PackageInfo) EnabledOptional() []string {
+	var result []string
+	for id, enabled := range info.Optional {
+		if enabled {
+			result = append(result, id)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Info returns information about installed package name-$version.
+// If package is not installed, returns ErrNotInstalled
+func (r *Root) Info(name, version string) (*PackageInfo, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	config, err := r.FindPackage(name, version)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("package %s-$%s is %w", name, version, ErrNotInstalled)
+	} else if err != nil {
+		return nil, err
+	}
+	info := &PackageInfo{PkgConfig: *config, Active: r.IsActive(name, version), Optional: make(map[string]bool)}
+	var activatedAt sql.NullTime
+	var optional sql.NullString
+	err = r.db.QueryRow("SELECT activated_at, optional FROM packages WHERE name = ? AND version = ?", name, version).
+		Scan(&activatedAt, &optional)
+	if err != nil {
+		return nil, fmt.Errorf("in Info: %v", err)
+	}
+	info.ActivatedAt = activatedAt.Time
+	info.AsDependency, err = r.IsDependency(name, version)
+	if err != nil {
+		return nil, fmt.Errorf("in Info: %v", err)
+	}
+	info.Dependents, err = r.ReverseDependencies(name, version)
+	if err != nil {
+		return nil, fmt.Errorf("in Info: %v", err)
+	}
+	var enabled []string
+	if optional.Valid {
+		enabled = strings.Split(optional.String, ";")
+	} else if enabled, err = r.enabledOptional(config); err != nil {
+		return nil, fmt.Errorf("in Info: %v", err)
+	}
+	for id, isRequired := range config.Dependencies {
+		if !isRequired {
+			info.Optional[id] = false
+		}
+	}
+	for _, id := range enabled {
+		if _, ok := info.Optional[id]; ok {
+			info.Optional[id] = true
+		}
+	}
+	return info, nil
+}
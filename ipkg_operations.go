@@ -1,24 +1,59 @@
 package ipkg
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	osextra "github.com/ira-package-manager/gobetter/os_extra"
 	"github.com/ira-package-manager/iscript"
 )
 
+// iscriptMu serializes running of IScripts, because IScript changes working directory of the process.
+// Root keeps absolute path, so other operations don't depend on working directory.
+// Relative paths are resolved under read lock, so they aren't resolved while IScript runs
+var iscriptMu sync.RWMutex
+
+// InstallOptions sets up how InstallPackageContext installs package
+type InstallOptions struct {
+	AsDependency bool // true if package is installed for another program, false if it is installed by user
+	// Sources are directories where optional dependencies are searched.
+	// Dependency must be a folder or IPKG archive named as its ID (name-$version or name-$version.ipkg)
+	Sources      []string
+	WithOptional bool // install all optional dependencies found in Sources
+	// AcceptOptional is asked whether optional dependency found in Sources must be installed, if WithOptional is false.
+	// If it is nil, optional dependencies are not installed
+	AcceptOptional func(id string) bool
+
+	installing map[string]bool // IDs of packages being installed, so cyclic optional dependencies are installed once
+}
+
 // InstallPackage installs package which should be set in path. If package is installed by user, asDependency must be false
 // If package must be installed for another program (as dependency), you should set it as true
 func (r *Root) InstallPackage(path string, asDependency bool) error {
+	return r.InstallPackageContext(context.Background(), path, InstallOptions{AsDependency: asDependency})
+}
+
+// InstallPackageContext installs package set in path. Cancelling ctx stops extracting, building
+// and installing package files, everything installed is removed then.
+// After package is added in database, installation can't be cancelled and is completed.
+// Optional dependencies are installed before package as set in opts and removed if package isn't installed.
+func (r *Root) InstallPackageContext(ctx context.Context, path string, opts InstallOptions) error {
+	// IScript of another installation can change working directory of the process, so relative path is resolved at once
+	iscriptMu.RLock()
+	path, err := filepath.Abs(path)
+	iscriptMu.RUnlock()
+	if err != nil {
+		return err
+	}
 	pkginfo, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("package %q doesn't exist", path)
@@ -27,216 +62,977 @@ func (r *Root) InstallPackage(path string, asDependency bool) error {
 	} else if err != nil {
 		return fmt.Errorf("os.Stat(%q): %w", path, err)
 	}
+	// Checksum is computed before building, because build script can change package folder
+	checksum, err := packageChecksum(path)
+	if err != nil {
+		return err
+	}
 	var workPath string
 	if pkginfo.IsDir() {
 		workPath = path // if package is a directory (unpacked), we can work there
 	} else if filepath.Ext(path) == ".ipkg" {
-		workPath, err = unzipPackage(path) // if package is IPKG, we need unpack it before working.
+		// if package is IPKG, we need unpack it before working.
+		finish := r.startPhase(OpInstall, "", "", PhaseExtract)
+		workPath, err = unzipPackage(ctx, path, func(done, total int64) {
+			r.emit(Event{Type: EventBytesExtracted, Operation: OpInstall, Phase: PhaseExtract, Bytes: done, Total: total, File: path})
+		})
+		finish(err)
 		if err != nil {
 			return err
 		}
+		defer os.RemoveAll(workPath)
 	} else {
-		return fmt.Errorf("file %s is not IRA package", path)
+		return fmt.Errorf("file %s is %w", path, ErrNotAPackage)
 	}
 
 	// Parsing configuration file
 	config, err := ParseConfig(filepath.Join(workPath, ".ira", "config.json"))
 	if os.IsNotExist(err) {
-		return fmt.Errorf("package has no config file")
+		return fmt.Errorf("%s is %w: package has no config file", path, ErrNotAPackage)
 	} else if err != nil {
 		return err
 	}
+	logger := r.pkgLogger(config.Name, config.Version)
+	// Optional dependencies are installed only if package can be installed, and before package, so it can use them
+	// right after installation. They lock their own names, so they are installed before package is locked
+	// If package isn't installed then, optional dependencies installed for it are removed
+	var optional []string
 	err = checkPackage(config, r)
+	if err == nil {
+		optional, err = r.installOptional(ctx, config, opts)
+	}
+	if err != nil {
+		logger.Error("package can't be installed", "error", err)
+		r.removeOptional(config, optional)
+		return err
+	}
+	// Checking and installing package are done under one lock, so nobody can install it meanwhile.
+	// Required dependencies are locked too, so they can't be removed before package is registered
+	names := []string{config.Name}
+	for id, isRequired := range config.Dependencies {
+		if name, _, err := ParseID(id); err == nil && isRequired {
+			names = append(names, name)
+		}
+	}
+	unlock, err := r.lockPackages(names...)
+	if err != nil {
+		r.removeOptional(config, optional)
+		return err
+	}
+	err = r.installLocked(ctx, config, path, workPath, checksum, opts)
+	unlock()
+	if err != nil {
+		r.removeOptional(config, optional)
+		return err
+	}
+	// Dependencies of old versions are removed too, so old versions are removed under their own locks
+	if err = r.removeOldVersions(config.Name); err != nil {
+		logger.Error("old versions aren't removed", "error", err)
+		return fmt.Errorf("removing old packages: %w", err)
+	}
+	return nil
+}
+
+// installLocked checks, builds, installs and activates package from workPath. Package and its dependencies must be locked
+func (r *Root) installLocked(ctx context.Context, config *PkgConfig, path, workPath, checksum string, opts InstallOptions) error {
+	logger := r.pkgLogger(config.Name, config.Version)
+	logger.Info("installing package", "path", path, "dependency", opts.AsDependency)
+	err := checkPackage(config, r)
 	if err != nil {
+		logger.Error("package can't be installed", "error", err)
 		return err
 	}
 	// Running build script if build option enabled
 	if config.Build {
-		err = buildPackage(workPath)
+		finish := r.startPhase(OpInstall, config.Name, config.Version, PhaseBuild)
+		err = r.buildPackage(ctx, config, workPath)
+		finish(err)
 		if err != nil {
 			return err
 		}
 	}
+	// Writing installation in journal, so it can be recovered if process is interrupted
+	op, err := r.beginOperation(OpInstall, config.Name, config.Version, stepInstallFiles)
+	if err != nil {
+		return err
+	}
+	err = r.installFiles(ctx, config, workPath)
+	if err == nil {
+		err = op.advance(stepRegister)
+	}
+	if err == nil {
+		finish := r.startPhase(OpInstall, config.Name, config.Version, PhaseDatabase)
+		err = r.registerPackage(ctx, config, checksum, opts.AsDependency)
+		finish(err)
+	}
+	if err != nil {
+		// Package isn't in database, so we're cleaning everything installed
+		if revertErr := r.revertInstallation(config.Name, config.Version); revertErr != nil {
+			return fmt.Errorf("%w (reverting installation: %v)", err, revertErr)
+		}
+		if finishErr := op.finish(); finishErr != nil {
+			return fmt.Errorf("%w (%v)", err, finishErr)
+		}
+		logger.Info("installation reverted", "error", err)
+		return err
+	}
+	// Package is in database now, so installation is finished even if steps below fail:
+	// otherwise failed step would be repeated by every recovery
+	err = r.completeInstallation(config, workPath, op)
+	if finishErr := op.finish(); finishErr != nil {
+		if err == nil {
+			return finishErr
+		}
+		return fmt.Errorf("%w (%v)", err, finishErr)
+	}
+	if err != nil {
+		logger.Error("package installed, but not activated", "error", err)
+		return err
+	}
+	logger.Info("package installed")
+	return nil
+}
+
+// completeInstallation caches registered package, keeps edited configuration and activates package.
+// Old versions are removed by caller after releasing locks
+func (r *Root) completeInstallation(config *PkgConfig, workPath string, op *Operation) error {
+	// Built package is kept, so damaged installation can be repaired without package file
+	if err := r.cachePackage(config.Name, config.Version, workPath); err != nil {
+		r.pkgLogger(config.Name, config.Version).Warn("package isn't cached", "error", err)
+	}
+	// Configuration edited by user is kept instead of pristine files of new version
+	if err := r.keepConfigFiles(config.Name, config.Version); err != nil {
+		return err
+	}
+	// After all, activating this package
+	err := op.advance(stepActivate)
+	if err != nil {
+		return err
+	}
+	finish := r.startPhase(OpInstall, config.Name, config.Version, PhaseActivate)
+	err = r.activatePackage(config.Name, config.Version)
+	finish(err)
+	if err != nil {
+		return fmt.Errorf("activating package: %w", err)
+	}
+	return nil
+}
+
+// installFiles creates installation folder and runs IScript from unpacked package in workPath
+func (r *Root) installFiles(ctx context.Context, config *PkgConfig, workPath string) (err error) {
+	finish := r.startPhase(OpInstall, config.Name, config.Version, PhaseIScript)
+	defer func() { finish(err) }()
 	// Creating installation folder
 	installDir := filepath.Join(r.path, config.Name+"-$"+config.Version)
-	if err = osextra.CreateIfNotExists(installDir, os.ModePerm); err != nil {
+	if err := osextra.CreateIfNotExists(installDir, os.ModePerm); err != nil {
 		return fmt.Errorf("creating installation folder: %w", err)
 	}
-	// Installing using IScript
-	parser, err := iscript.NewParser(
-		filepath.Join(workPath, ".ira", "iscript"),
-		installDir)
+	// Activation links are created by Root when package is activated, so IScript runs without activate instructions
+	script, err := os.ReadFile(filepath.Join(workPath, ".ira", "iscript"))
 	if err != nil {
-		return err
+		return fmt.Errorf("reading IScript: %w", err)
 	}
-	err = parser.Start(iscript.Install, workPath)
+	script, links, err := extractActivation(script)
 	if err != nil {
 		return fmt.Errorf("parsing iscript: %w", err)
 	}
-	// Copying IScript for future manipulations
-	if err = osextra.CreateIfNotExists(filepath.Join(installDir, ".ira"), os.ModePerm); err != nil {
-		return fmt.Errorf("creating configuration folder: %w", err)
+	// Conflicting links are found before installing anything
+	if err = r.checkLinks(config.Name, links); err != nil {
+		return err
 	}
-
+	// Installing using IScript
+	if err = runInstallScript(ctx, script, workPath, installDir); err != nil {
+		return err
+	}
+	if err = r.reportInstalledFiles(config, installDir); err != nil {
+		return err
+	}
+	if err = writeActivationLog(installDir, links); err != nil {
+		return err
+	}
+	// Copying IScript for future manipulations
 	err = osextra.Copy(filepath.Join(workPath, ".ira", "iscript"), filepath.Join(installDir, ".ira", "iscript"))
 	if err != nil {
 		return fmt.Errorf("saving IScript: %w", err)
 	}
-	// Now, we need to add package in database
+	return nil
+}
+
+// runInstallScript runs install section of IScript script from unpacked package in workPath, installing files into installDir
+func runInstallScript(ctx context.Context, script []byte, workPath, installDir string) error {
+	if err := osextra.CreateIfNotExists(filepath.Join(installDir, ".ira"), os.ModePerm); err != nil {
+		return fmt.Errorf("creating configuration folder: %w", err)
+	}
+	scriptPath := filepath.Join(installDir, ".ira", "iscript.install")
+	if err := os.WriteFile(scriptPath, script, 0644); err != nil {
+		return fmt.Errorf("saving IScript: %w", err)
+	}
+	defer os.Remove(scriptPath)
+	parser, err := iscript.NewParser(scriptPath, installDir)
+	if err != nil {
+		return err
+	}
+	// Note: IScript can't be stopped while running, so cancellation is checked before and after it
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	iscriptMu.Lock()
+	err = parser.Start(iscript.Install, workPath)
+	iscriptMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("parsing iscript: %w", err)
+	}
+	return ctx.Err()
+}
+
+// reportInstalledFiles sends EventFileInstalled for every file in installation folder
+func (r *Root) reportInstalledFiles(config *PkgConfig, installDir string) error {
+	if len(r.observers) == 0 {
+		return nil
+	}
+	return filepath.WalkDir(installDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".ira" {
+				return filepath.SkipDir // package metadata isn't installed by IScript
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(installDir, path)
+		if err != nil {
+			return err
+		}
+		r.emit(Event{Type: EventFileInstalled, Operation: OpInstall, Name: config.Name, Version: config.Version, Phase: PhaseIScript, File: rel})
+		return nil
+	})
+}
+
+// registerPackage adds installed package and its files in database
+func (r *Root) registerPackage(ctx context.Context, config *PkgConfig, checksum string, asDependency bool) error {
 	var byUser int
 	if asDependency {
 		byUser = 0
 	} else {
 		byUser = 1
 	}
-	_, err = r.db.Exec("INSERT INTO packages VALUES (NULL, ?, ?, ?, ?, 0)", config.Name, config.Version, config.SerializeDependencies(), byUser)
+	installDir := filepath.Join(r.path, PackageID(config.Name, config.Version))
+	files, err := collectFiles(installDir)
 	if err != nil {
-		return fmt.Errorf("adding package to database: %v", err)
+		return err
 	}
-	// After all, activating this package
-	err = r.ActivatePackage(config.Name, config.Version)
+	optional, err := r.enabledOptional(config)
 	if err != nil {
-		return fmt.Errorf("activating package: %w", err)
+		return err
 	}
-	err = r.removeOld(config.Name)
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("removing old packages: %w", err)
+		return fmt.Errorf("adding package to database: %w", err)
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, "INSERT INTO packages (name, version, dependencies, by_user, checksum, optional) VALUES (?, ?, ?, ?, ?, ?)",
+		config.Name, config.Version, config.SerializeDependencies(), byUser, checksum, strings.Join(optional, ";"))
+	if err != nil {
+		return fmt.Errorf("adding package to database: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("adding package to database: %w", err)
+	}
+	if err = recordFiles(ctx, tx, id, files); err != nil {
+		return err
+	}
+	if err = markConfigFiles(ctx, tx, id, installDir, config.ConfigFiles); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("adding package to database: %w", err)
 	}
 	return nil
 }
 
+// unregisterPackage removes package and its files from database
+func (r *Root) unregisterPackage(name, version string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("removing package from database: %w", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM files WHERE package_id IN (SELECT id FROM packages WHERE name = ? AND version = ?)", name, version)
+	if err != nil {
+		return fmt.Errorf("removing package from database: %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM packages WHERE name = ? AND version = ?", name, version)
+	if err != nil {
+		return fmt.Errorf("removing package from database: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("removing package from database: %w", err)
+	}
+	return nil
+}
+
+// ActivatePackage activates package name-$version and deactivates its other versions
 func (r *Root) ActivatePackage(name, version string) error {
-	err := r.activate(name, version)
+	unlock, err := r.lockPackage(name)
 	if err != nil {
 		return err
 	}
-	pkgs, err := r.FindPackagesByName(name)
+	defer unlock()
+	return r.activatePackage(name, version)
+}
+
+func (r *Root) activatePackage(name, version string) (err error) {
+	op, err := r.beginOperation(OpActivate, name, version, stepSwitch)
 	if err != nil {
-		return fmt.Errorf("getting all packages: %w", err)
+		return err
 	}
-	for _, pkg := range pkgs {
-		if pkg.Version == version {
-		} else {
-			err = r.deactivate(pkg.Name, pkg.Version)
-			if err != nil {
-				return fmt.Errorf("deactivating %s-$%s: %w", name, version, err)
+	// Failed activation is removed from journal too, otherwise it would be repeated by every recovery
+	defer func() {
+		if finishErr := op.finish(); finishErr != nil && err == nil {
+			err = finishErr
+		}
+	}()
+	// Links of versions installed by older ipkg point to their folders, so they are removed before creating new ones
+	if _, ok := r.activeVersion(name); !ok {
+		pkgs, err := r.FindPackagesByName(name)
+		if err != nil {
+			return fmt.Errorf("getting all packages: %w", err)
+		}
+		for _, pkg := range pkgs {
+			if pkg.Version != version {
+				err = r.deactivate(OpActivate, pkg.Name, pkg.Version)
+				if err != nil {
+					return fmt.Errorf("deactivating %s-$%s: %w", pkg.Name, pkg.Version, err)
+				}
 			}
 		}
 	}
+	err = r.activate(name, version)
+	if err != nil {
+		return err
+	}
+	r.pkgLogger(name, version).Info("package activated")
 	return nil
 }
 
+// RemoveOptions sets up how RemovePackageWithOptions removes package
+type RemoveOptions struct {
+	RemoveDependencies bool // if true, dependencies which are not required by anyone else are removed too
+	Cascade            bool // if true, packages requiring removed package are removed too, otherwise removing is refused
+	// Purge removes configuration files edited by user, otherwise they are saved and restored when package is installed again
+	Purge bool
+}
+
+// DependentsError is returned when package can't be removed because other installed packages require it
+type DependentsError struct {
+	Name       string
+	Version    string
+	Dependents []PkgConfig
+}
+
+func (e *DependentsError) Error() string {
+	ids := make([]string, len(e.Dependents))
+	for i, pkg := range e.Dependents {
+		ids[i] = PackageID(pkg.Name, pkg.Version)
+	}
+	return fmt.Sprintf("package %s is required by %s", PackageID(e.Name, e.Version), strings.Join(ids, ", "))
+}
+
+// RemovePackage removes package name-$version. If removeDependencies is true, its dependencies
+// which were installed as dependencies and aren't required by anyone else are removed too.
+// If other packages require this package, *DependentsError is returned.
 func (r *Root) RemovePackage(name, version string, removeDependencies bool) error {
+	return r.RemovePackageWithOptions(name, version, RemoveOptions{RemoveDependencies: removeDependencies})
+}
+
+// RemovePackageWithOptions removes package name-$version as RemovalPlan describes it
+func (r *Root) RemovePackageWithOptions(name, version string, opts RemoveOptions) error {
+	return r.RemovePackageContext(context.Background(), name, version, opts)
+}
+
+// RemovePackageContext removes package name-$version as RemovalPlan describes it.
+// Cancelling ctx stops removing before the next package of plan, package being removed is removed completely.
+func (r *Root) RemovePackageContext(ctx context.Context, name, version string, opts RemoveOptions) error {
+	// Removing can touch dependents and dependencies, so it doesn't run together with other operations
+	unlock, err := r.lockAll()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	plan, err := r.RemovalPlan(name, version, opts)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range plan {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		// Package could be already removed as unused dependency of previous one
+		if _, err = r.FindPackage(pkg.Name, pkg.Version); err == sql.ErrNoRows {
+			continue
+		}
+		err = r.removePackage(pkg.Name, pkg.Version, opts.RemoveDependencies, opts.Purge)
+		if err != nil {
+			return fmt.Errorf("removing %s: %w", PackageID(pkg.Name, pkg.Version), err)
+		}
+	}
+	return nil
+}
+
+// RemovalPlan returns packages which would be removed with package name-$version in order of removing:
+// dependents go before packages they require and package itself goes after them. If opts.RemoveDependencies is true,
+// each package is followed by its dependencies which aren't installed by user and aren't required by anyone else then.
+// If opts.Cascade is false and package has dependents, *DependentsError is returned.
+func (r *Root) RemovalPlan(name, version string, opts RemoveOptions) ([]PkgConfig, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
 	pkg, err := r.FindPackage(name, version)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("package %s-$%s is not installed", name, version)
+		return nil, fmt.Errorf("package %s-$%s is %w", name, version, ErrNotInstalled)
+	} else if err != nil {
+		return nil, err
 	}
-	if removeDependencies {
-		err = pkg.ForEachDependency(r.removeDependency)
+	var plan []PkgConfig
+	if !opts.Cascade {
+		dependents, err := r.ReverseDependencies(name, version)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if len(dependents) != 0 {
+			return nil, &DependentsError{Name: name, Version: version, Dependents: dependents}
+		}
+		plan = []PkgConfig{*pkg}
+	} else {
+		visited := make(map[string]bool)
+		var visit func(pkg PkgConfig) error
+		visit = func(pkg PkgConfig) error {
+			id := PackageID(pkg.Name, pkg.Version)
+			if visited[id] {
+				return nil
+			}
+			visited[id] = true
+			dependents, err := r.ReverseDependencies(pkg.Name, pkg.Version)
+			if err != nil {
+				return err
+			}
+			for _, dependent := range dependents {
+				if err = visit(dependent); err != nil {
+					return err
+				}
+			}
+			plan = append(plan, pkg)
+			return nil
+		}
+		if err = visit(*pkg); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.RemoveDependencies {
+		return plan, nil
+	}
+	return r.withDependencies(plan)
+}
+
+// withDependencies adds to removal plan dependencies which removePackage removes after each package of plan
+func (r *Root) withDependencies(plan []PkgConfig) ([]PkgConfig, error) {
+	pkgs, err := r.Packages()
+	if err != nil {
+		return nil, err
+	}
+	installed := make(map[string]PkgConfig)
+	for _, pkg := range pkgs {
+		installed[PackageID(pkg.Name, pkg.Version)] = pkg
+	}
+	removed := make(map[string]bool)
+	// required checks if package id is required by package which isn't removed yet
+	required := func(id string) bool {
+		for other, pkg := range installed {
+			if !removed[other] && pkg.Dependencies[id] {
+				return true
+			}
+		}
+		return false
+	}
+	var result []PkgConfig
+	var remove func(pkg PkgConfig) error
+	remove = func(pkg PkgConfig) error {
+		result = append(result, pkg)
+		removed[PackageID(pkg.Name, pkg.Version)] = true
+		ids := make([]string, 0, len(pkg.Dependencies))
+		for id := range pkg.Dependencies {
+			ids = append(ids, id)
 		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			dependency, ok := installed[id]
+			if !ok || removed[id] || required(id) {
+				continue
+			}
+			isDependency, err := r.IsDependency(dependency.Name, dependency.Version)
+			if err != nil {
+				return err
+			}
+			if !isDependency {
+				continue
+			}
+			if err = remove(dependency); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, pkg := range plan {
+		if removed[PackageID(pkg.Name, pkg.Version)] {
+			continue
+		}
+		if err = remove(pkg); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (r *Root) removePackage(name, version string, removeDependencies, purge bool) error {
+	pkg, err := r.FindPackage(name, version)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("package %s-$%s is %w", name, version, ErrNotInstalled)
+	} else if err != nil {
+		return err
+	}
+	logger := r.pkgLogger(name, version)
+	logger.Info("removing package", "remove_dependencies", removeDependencies)
+	op, err := r.beginOperation(OpRemove, name, version, stepDeactivate)
+	if err != nil {
+		return err
+	}
+	finish := r.startPhase(OpRemove, name, version, PhaseActivate)
+	err = r.deactivate(OpRemove, name, version)
+	finish(err)
+	if err != nil {
+		return err
 	}
-	err = r.deactivate(name, version)
+	// Recorded hashes are needed to find edited configuration, so it is saved before package leaves database
+	err = r.saveConfigFiles(name, version, purge)
 	if err != nil {
 		return err
 	}
-	_, err = r.db.Exec("DELETE FROM packages WHERE name = ? AND version = ?", name, version)
+	finish = r.startPhase(OpRemove, name, version, PhaseDatabase)
+	err = r.unregisterPackage(name, version)
+	finish(err)
 	if err != nil {
-		return fmt.Errorf("removing package from database: %v", err)
+		return err
+	}
+	err = op.advance(stepRemoveFiles)
+	if err != nil {
+		return err
+	}
+	err = r.removeFiles(name, version)
+	if err != nil {
+		return err
 	}
+	err = op.finish()
+	if err != nil {
+		return err
+	}
+	logger.Info("package removed")
+	// Dependencies are removed after package itself, so it doesn't hold them anymore
+	if removeDependencies {
+		err = pkg.ForEachDependency(r.removeDependency)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFiles runs remove section of IScript and removes installation folder of package name-$version
+func (r *Root) removeFiles(name, version string) (err error) {
+	finish := r.startPhase(OpRemove, name, version, PhaseIScript)
+	defer func() { finish(err) }()
 	path := filepath.Join(r.path, name+"-$"+version)
 	parser, err := iscript.NewParser(filepath.Join(path, ".ira", "iscript"), path)
 	if err != nil {
 		return err
 	}
+	iscriptMu.Lock()
 	err = parser.Start(iscript.Remove, "")
+	iscriptMu.Unlock()
 	if err != nil {
 		return fmt.Errorf("parsing iscript: %w", err)
 	}
 	err = os.RemoveAll(path)
 	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("removing package files: %v", err)
+		return fmt.Errorf("removing package files: %w", err)
 	}
-	return nil
+	return r.removeCache(name, version)
 }
 
+// removeOldVersions locks package name with all dependencies of its versions and removes the oldest version
+// if too many versions are installed. Locks are taken in fixed order, so it can't deadlock with installations
+func (r *Root) removeOldVersions(name string) error {
+	for {
+		names, err := r.versionsDependencies(name)
+		if err != nil {
+			return err
+		}
+		unlock, err := r.lockPackages(names...)
+		if err != nil {
+			return err
+		}
+		// Another version could be installed while we were waiting for locks
+		locked, err := r.versionsDependencies(name)
+		if err == nil && subset(locked, names) {
+			err = r.removeOld(name)
+			unlock()
+			return err
+		}
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// versionsDependencies returns name and names of all dependencies (including indirect ones) of installed versions of package name
+func (r *Root) versionsDependencies(name string) ([]string, error) {
+	pkgs, err := r.FindPackagesByName(name)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{name}
+	visited := make(map[string]bool)
+	for len(pkgs) != 0 {
+		pkg := pkgs[len(pkgs)-1]
+		pkgs = pkgs[:len(pkgs)-1]
+		for id := range pkg.Dependencies {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			depName, depVersion, err := ParseID(id)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, depName)
+			dep, err := r.FindPackage(depName, depVersion)
+			if err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			pkgs = append(pkgs, *dep)
+		}
+	}
+	return names, nil
+}
+
+// subset checks that every element of a is in b
+func subset(a, b []string) bool {
+	set := make(map[string]bool)
+	for _, s := range b {
+		set[s] = true
+	}
+	for _, s := range a {
+		if !set[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// removeOld removes the oldest version of package name if more than 5 versions are installed.
+// Package name and its dependencies must be locked
 func (r *Root) removeOld(name string) error {
 	pkgs, err := r.FindPackagesByName(name)
 	if err != nil {
 		return err
 	}
 	if len(pkgs) > 5 {
-		SortByVersion.Sort(pkgs, true)
+		// The oldest version is removed
+		SortByVersion.Sort(pkgs, false)
 		pkgToRemove := pkgs[0]
-		if !r.IsActive(pkgToRemove.Name, pkgToRemove.Version) {
-			return r.RemovePackage(pkgToRemove.Name, pkgToRemove.Version, true)
+		active, err := r.isActive(pkgToRemove.Name, pkgToRemove.Version)
+		if err != nil {
+			return err
+		}
+		if active {
+			return nil
+		}
+		canBeRemoved, err := r.CanBeRemoved(pkgToRemove.Name, pkgToRemove.Version)
+		if err != nil {
+			return err
+		}
+		if canBeRemoved { // old versions still required by someone are kept
+			return r.removePackage(pkgToRemove.Name, pkgToRemove.Version, true, false)
 		}
 	}
 	return nil
 }
 
+// activate creates links of package name-$version and switches current/<name> to it.
+// Links of previously active version which new version doesn't have are removed after switching.
+// If any link conflicts with another package or file, nothing is changed
 func (r *Root) activate(name, version string) error {
 	if _, err := r.FindPackage(name, version); err == sql.ErrNoRows {
-		return fmt.Errorf("package %s-$%s is not installed", name, version)
+		return fmt.Errorf("package %s-$%s is %w", name, version, ErrNotInstalled)
 	}
-	path := filepath.Join(r.path, name+"-$"+version)
-	if r.IsActive(name, version) {
-		return nil // activated
+	path := filepath.Join(r.path, PackageID(name, version))
+	links, _, err := readActivationLog(path)
+	if err != nil {
+		return err
+	}
+	if err = r.checkLinks(name, links); err != nil {
+		return err
 	}
-	log := filepath.Join(path, ".ira", "activate.log")
-	if osextra.Exists(log) {
-		file, err := os.Open(log)
+	// Links created by this call are removed if package isn't activated, so failed activation leaves no links
+	var created []activationLink
+	for _, link := range links {
+		isNew, err := r.createLink(name, version, link)
+		if isNew {
+			created = append(created, link)
+		}
 		if err != nil {
-			return fmt.Errorf("opening activation log: %w", err)
+			r.removeCreatedLinks(name, version, created)
+			return err
 		}
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			parsedLog := strings.Split(scanner.Text(), " ")
-			err = os.Symlink(parsedLog[0], parsedLog[1])
-			if err != nil {
-				return err
+	}
+	previous, hasPrevious := r.activeVersion(name)
+	if err = r.switchCurrent(name, version); err != nil {
+		r.removeCreatedLinks(name, version, created)
+		return err
+	}
+	if err = r.setActive(name, version); err != nil {
+		return err
+	}
+	if hasPrevious && previous != version {
+		previousPath := filepath.Join(r.path, PackageID(name, previous))
+		oldLinks, _, err := readActivationLog(previousPath)
+		if err != nil {
+			return err
+		}
+		kept := make(map[string]bool)
+		for _, link := range links {
+			kept[link.Link] = true
+		}
+		for _, link := range oldLinks {
+			if !kept[link.Link] {
+				if err = r.removeLink(OpActivate, name, previous, link); err != nil {
+					return err
+				}
 			}
 		}
-		if scanner.Err() != nil {
-			return fmt.Errorf("scanning activation log: %w", err)
+	}
+	return nil
+}
+
+// setActive marks package name-$version as the only active version of package in database
+func (r *Root) setActive(name, version string) error {
+	_, err := r.db.Exec(`UPDATE packages SET
+		active = (version = ?),
+		activated_at = CASE WHEN version = ? THEN ? ELSE activated_at END
+		WHERE name = ?`, version, version, time.Now().UTC(), name)
+	if err != nil {
+		return fmt.Errorf("saving active version of %s: %w", name, err)
+	}
+	return nil
+}
+
+// createLink creates activation link of package name-$version pointing to current/<name> and records its owner.
+// Link to installation folder of package created by older ipkg is replaced. Returns true if link didn't exist before
+func (r *Root) createLink(name, version string, link activationLink) (bool, error) {
+	target, err := r.linkTarget(name, link.Target)
+	if err != nil {
+		return false, err
+	}
+	created := false
+	existing, err := os.Readlink(link.Link)
+	switch {
+	case err == nil && existing == target:
+		// created by previous version or interrupted activation
+	case err == nil && r.isLegacyTarget(name, existing):
+		// Replacing link atomically
+		tmp := link.Link + ".ipkg-tmp"
+		os.Remove(tmp)
+		if err = os.Symlink(target, tmp); err != nil {
+			return false, fmt.Errorf("creating link %s: %w", link.Link, err)
+		}
+		if err = os.Rename(tmp, link.Link); err != nil {
+			os.Remove(tmp)
+			return false, fmt.Errorf("creating link %s: %w", link.Link, err)
+		}
+	default:
+		if err = os.Symlink(target, link.Link); err != nil {
+			return false, fmt.Errorf("creating link %s: %w", link.Link, err)
+		}
+		created = true
+	}
+	_, err = r.db.Exec("INSERT OR REPLACE INTO links VALUES (?, ?, ?)", link.Link, name, version)
+	if err != nil {
+		return created, fmt.Errorf("recording owner of %s: %w", link.Link, err)
+	}
+	return created, nil
+}
+
+// removeCreatedLinks removes links created by failed activation of package name-$version and their owners
+func (r *Root) removeCreatedLinks(name, version string, links []activationLink) {
+	logger := r.pkgLogger(name, version)
+	for _, link := range links {
+		if err := os.Remove(link.Link); err != nil && !os.IsNotExist(err) {
+			logger.Warn("link of failed activation isn't removed", "path", link.Link, "error", err)
+			continue
+		}
+		if _, err := r.db.Exec("DELETE FROM links WHERE path = ? AND name = ? AND version = ?", link.Link, name, version); err != nil {
+			logger.Warn("owner of removed link isn't removed", "path", link.Link, "error", err)
+		}
+	}
+}
+
+// isLegacyTarget checks if target is a path inside installation folder of any version of package name
+func (r *Root) isLegacyTarget(name, target string) bool {
+	prefixes := []string{filepath.Join(r.path, name+"-$")}
+	if abs, err := filepath.Abs(prefixes[0]); err == nil {
+		prefixes = append(prefixes, abs)
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(target, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeLink removes activation link of package name-$version if package still owns it.
+// Path taken by another package or file is left untouched and reported to observers of operation
+func (r *Root) removeLink(operation, name, version string, link activationLink) error {
+	owner, _, err := r.linkOwner(link.Link)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != name {
+		r.skipLink(operation, name, version, link.Link, "path belongs to package "+owner)
+		return nil
+	}
+	target, err := r.linkTarget(name, link.Target)
+	if err != nil {
+		return err
+	}
+	if err = r.unlink(operation, name, version, link.Link, target); err != nil {
+		return err
+	}
+	_, err = r.db.Exec("DELETE FROM links WHERE path = ? AND name = ?", link.Link, name)
+	if err != nil {
+		return fmt.Errorf("removing owner of %s: %w", link.Link, err)
+	}
+	return nil
+}
+
+// unlink removes symbolic link in path if it points to one of targets.
+// If path is missing, isn't a symbolic link or points elsewhere, it is skipped and reported to observers
+func (r *Root) unlink(operation, name, version, path string, targets ...string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		r.pkgLogger(name, version).Debug("link is already removed", "path", path)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("checking link %s: %w", path, err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		r.skipLink(operation, name, version, path, "not a symbolic link")
+		return nil
+	}
+	existing, err := os.Readlink(path)
+	if err != nil {
+		return fmt.Errorf("reading link %s: %w", path, err)
+	}
+	for _, target := range targets {
+		if existing == target {
+			if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("removing link %s: %w", path, err)
+			}
+			return nil
 		}
-		file.Close()
-		os.Remove(filepath.Join(path, ".ira", "deactivated"))
 	}
+	r.skipLink(operation, name, version, path, "link points to "+existing)
 	return nil
 }
 
-func (r *Root) deactivate(name, version string) error {
+// skipLink reports activation link of package name-$version which was left untouched by operation
+func (r *Root) skipLink(operation, name, version, path, reason string) {
+	r.pkgLogger(name, version).Warn("link skipped", "path", path, "reason", reason)
+	r.emit(Event{Type: EventLinkSkipped, Operation: operation, Name: name, Version: version, Phase: PhaseActivate, File: path, Detail: reason})
+}
+
+func (r *Root) deactivate(operation, name, version string) error {
 	if _, err := r.FindPackage(name, version); err == sql.ErrNoRows {
-		return fmt.Errorf("package %s-$%s is not installed", name, version)
+		return fmt.Errorf("package %s-$%s is %w", name, version, ErrNotInstalled)
 	}
-	path := filepath.Join(r.path, name+"-$"+version)
-	if !r.IsActive(name, version) {
+	path := filepath.Join(r.path, PackageID(name, version))
+	active, err := r.isActive(name, version)
+	if err != nil {
+		return err
+	}
+	if !active {
 		return nil // deactivated
 	}
-	log := filepath.Join(path, ".ira", "activate.log")
-	if osextra.Exists(log) {
-		file, err := os.Open(log)
+	if current, ok := r.activeVersion(name); ok && current == version {
+		links, _, err := readActivationLog(path)
 		if err != nil {
-			return fmt.Errorf("opening activation log: %w", err)
+			return err
 		}
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			// Note: igroring errors
-			os.Remove(strings.Split(scanner.Text(), " ")[1])
+		for _, link := range links {
+			if err = r.removeLink(operation, name, version, link); err != nil {
+				return err
+			}
+		}
+		if err = os.Remove(r.currentLink(name)); err != nil {
+			return fmt.Errorf("removing link to active version: %w", err)
 		}
-		if scanner.Err() != nil {
-			return fmt.Errorf("scanning activation log: %w", err)
+	} else {
+		// Package was activated by older ipkg
+		if err := r.removeActivationLinks(operation, name, version); err != nil {
+			return err
 		}
-		file.Close()
-		flag, err := os.Create(filepath.Join(path, ".ira", "deactivated"))
+	}
+	_, err = r.db.Exec("UPDATE packages SET active = 0 WHERE name = ? AND version = ?", name, version)
+	if err != nil {
+		return fmt.Errorf("saving deactivation of %s-$%s: %w", name, version, err)
+	}
+	r.pkgLogger(name, version).Info("package deactivated")
+	return nil
+}
+
+// removeActivationLinks removes links pointing directly to installation folder of package name-$version,
+// which were created by older ipkg or IScript
+func (r *Root) removeActivationLinks(operation, name, version string) error {
+	path := filepath.Join(r.path, PackageID(name, version))
+	links, _, err := readActivationLog(path)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		owner, _, err := r.linkOwner(link.Link)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != name {
+			r.skipLink(operation, name, version, link.Link, "path belongs to package "+owner)
+			continue
+		}
+		if err = r.unlink(operation, name, version, link.Link, legacyTarget(path, link), legacyTarget(abs, link)); err != nil {
+			return err
+		}
+		_, err = r.db.Exec("DELETE FROM links WHERE path = ? AND name = ?", link.Link, name)
 		if err != nil {
-			return fmt.Errorf("creating flag file: %w", err)
+			return fmt.Errorf("removing owner of %s: %w", link.Link, err)
 		}
-		flag.Close()
 	}
 	return nil
 }
@@ -246,55 +1042,22 @@ func (r *Root) removeDependency(name, version string, isRequired bool) error {
 	if err == sql.ErrNoRows {
 		return nil
 	} else if err != nil {
-		return fmt.Errorf("checking is %s-$%s a dependency : %v", name, version, err)
+		return fmt.Errorf("checking is %s-$%s a dependency : %w", name, version, err)
 	}
 	if !isDependency {
 		return nil
 	}
 	canBeRemoved, err := r.CanBeRemoved(name, version)
 	if err != nil {
-		return fmt.Errorf("checking can %s-$%s be removed: %v", name, version, err)
+		return fmt.Errorf("checking can %s-$%s be removed: %w", name, version, err)
 	}
 	if !canBeRemoved {
 		return nil
 	}
-	err = r.RemovePackage(name, version, true)
-	if err != nil {
-		return fmt.Errorf("removing dependency %s-$%s: %v", name, version, err)
-	}
-	return nil
-}
-
-// Prepares package before unzipping
-func prepareCompressedPackage(path, tempDir string) (string, error) {
-	// Creating temporary dir if not exists
-	err := osextra.CreateIfNotExists(tempDir, os.ModePerm)
-	if err != nil {
-		return "", fmt.Errorf("making temporary dir: %v", err)
-	}
-
-	archivePath := filepath.Join(tempDir, strings.TrimSuffix(filepath.Base(path), ".ipkg")+".zip") // path to new zip archive
-
-	// Copying IPKG to temporary folder as ZIP archive
-	if err = osextra.Copy(path, archivePath); err != nil {
-		return "", fmt.Errorf("copying package %s to temporary place %s: %v", path, archivePath, err)
-	}
-	return archivePath, nil
-}
-
-func buildPackage(pkgPath string) error {
-	buildscriptPath := filepath.Join(pkgPath, ".ira", "build")
-	if runtime.GOOS == "windows" {
-		buildscriptPath += ".bat"
-	}
-	buildscript := exec.Command(buildscriptPath)
-	var out bytes.Buffer
-	buildscript.Stdout = &out
-	err := buildscript.Run()
+	err = r.removePackage(name, version, true, false)
 	if err != nil {
-		return fmt.Errorf("executing build script: %w", err)
+		return fmt.Errorf("removing dependency %s-$%s: %w", name, version, err)
 	}
-	log.Println("script output: ", out.String())
 	return nil
 }
 
@@ -303,30 +1066,30 @@ func checkPackage(config *PkgConfig, r *Root) error {
 	switch runtime.GOOS {
 	case "linux":
 		if !config.SupportLinux {
-			return fmt.Errorf("unsupported os: %v", runtime.GOOS)
+			return fmt.Errorf("%w: %v", ErrUnsupportedPlatform, runtime.GOOS)
 		}
 	case "windows":
 		if !config.SupportWindows {
-			return fmt.Errorf("unsupported os: %v", runtime.GOOS)
+			return fmt.Errorf("%w: %v", ErrUnsupportedPlatform, runtime.GOOS)
 		}
 	default:
-		return fmt.Errorf("unsupported os: %v", runtime.GOOS)
+		return fmt.Errorf("%w: %v", ErrUnsupportedPlatform, runtime.GOOS)
 	}
 
 	// Checking is package installed
 	_, err := r.FindPackage(config.Name, config.Version)
 	if err == nil {
-		return fmt.Errorf("package %s-$%s is already installed", config.Name, config.Version)
+		return fmt.Errorf("package %s-$%s is %w", config.Name, config.Version, ErrAlreadyInstalled)
 	} else if err != sql.ErrNoRows {
 		return fmt.Errorf("checking is package installed: %w", err)
 	}
 	// Checking dependencies
-	ok, err := config.CheckDependencies(r)
+	report, err := config.DependencyReport(r)
 	if err != nil {
 		return err
 	}
-	if !ok {
-		return fmt.Errorf("not all required dependencies statisfied")
+	if !report.Satisfied() {
+		return &MissingDependenciesError{Name: config.Name, Version: config.Version, Missing: report.Missing, Report: report}
 	}
 	return nil
 }
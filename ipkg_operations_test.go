@@ -1,8 +1,19 @@
 package ipkg_test
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	osextra "github.com/ira-package-manager/gobetter/os_extra"
 	"github.com/ira-package-manager/ipkg"
@@ -14,6 +25,7 @@ func TestInstallUncompressed(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer root.Close()
 	// Checking installisation
 	err = root.InstallPackage("./test/pkgs/testpkg", true)
 	if err != nil {
@@ -27,6 +39,7 @@ func TestInstallCompressed(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer root.Close()
 	// Checking installisation
 	err = root.InstallPackage("./test/pkgs/testpkg.ipkg", true)
 	if err != nil {
@@ -69,3 +82,472 @@ func testRemove(root *ipkg.Root, t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestRemoveRequiredPackage(t *testing.T) {
+	root, err := ipkg.OpenRoot("./test/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err = root.InstallPackage("./test/pkgs/testpkg", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage("./test/pkgs/deppkg", false); err != nil {
+		t.Fatal(err)
+	}
+	// Removing without cascade must be refused
+	var dependentsErr *ipkg.DependentsError
+	err = root.RemovePackage("testpkg", "1.0", true)
+	if !errors.As(err, &dependentsErr) {
+		t.Fatalf("expected DependentsError, got %v", err)
+	}
+	if len(dependentsErr.Dependents) != 1 || dependentsErr.Dependents[0].Name != "deppkg" {
+		t.Errorf("wrong dependents: %v", dependentsErr.Dependents)
+	}
+	if !osextra.Exists("./test/db/testpkg-$1.0") {
+		t.Fatal("required package was removed")
+	}
+	// Cascading removal removes dependents first
+	plan, err := root.RemovalPlan("testpkg", "1.0", ipkg.RemoveOptions{Cascade: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || plan[0].Name != "deppkg" || plan[1].Name != "testpkg" {
+		t.Fatalf("wrong removal plan: %v", plan)
+	}
+	err = root.RemovePackageWithOptions("testpkg", "1.0", ipkg.RemoveOptions{RemoveDependencies: true, Cascade: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if osextra.Exists("./test/db/testpkg-$1.0") || osextra.Exists("./test/db/deppkg-$1.0") {
+		t.Error("packages weren't removed")
+	}
+}
+
+func TestRemovalPlanDependencies(t *testing.T) {
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir := t.TempDir()
+	for _, pkg := range []struct {
+		path         string
+		asDependency bool
+	}{
+		{writePackage(t, dir, "base", "1.0", nil), true},
+		{writePackage(t, dir, "shared", "1.0", nil), true},
+		{writePackage(t, dir, "lib", "1.0", map[string]bool{"base-$1.0": true}), true},
+		{writePackage(t, dir, "tool", "1.0", map[string]bool{"shared-$1.0": true}), false},
+		{writePackage(t, dir, "app", "1.0", map[string]bool{"lib-$1.0": true, "shared-$1.0": true}), false},
+	} {
+		if err = root.InstallPackage(pkg.path, pkg.asDependency); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// shared is still required by tool, so it stays
+	plan, err := root.RemovalPlan("app", "1.0", ipkg.RemoveOptions{RemoveDependencies: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, pkg := range plan {
+		ids = append(ids, ipkg.PackageID(pkg.Name, pkg.Version))
+	}
+	if strings.Join(ids, " ") != "app-$1.0 lib-$1.0 base-$1.0" {
+		t.Fatalf("wrong removal plan: %v", ids)
+	}
+	if err = root.RemovePackage("app", "1.0", true); err != nil {
+		t.Fatal(err)
+	}
+	installed, err := root.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != 2 {
+		t.Errorf("removed packages differ from plan: %v", installed)
+	}
+}
+
+func TestRemoveUnusedDependencies(t *testing.T) {
+	root, err := ipkg.OpenRoot("./test/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err = root.InstallPackage("./test/pkgs/testpkg", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage("./test/pkgs/deppkg", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.RemovePackage("deppkg", "1.0", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = root.FindPackage("testpkg", "1.0"); err != sql.ErrNoRows {
+		t.Errorf("unused dependency wasn't removed: %v", err)
+	}
+}
+
+func TestRemoveDependencyError(t *testing.T) {
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir := t.TempDir()
+	if err = root.InstallPackage(writePackage(t, dir, "lib", "1.0", nil), true); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage(writePackage(t, dir, "app", "1.0", map[string]bool{"lib-$1.0": true}), false); err != nil {
+		t.Fatal(err)
+	}
+	// Activation log of dependency can't be read, so dependency isn't deactivated
+	log := filepath.Join(path, ipkg.PackageID("lib", "1.0"), ".ira", "activate.log")
+	if err = os.Remove(log); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(log, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	err = root.RemovePackage("app", "1.0", true)
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || filepath.Base(pathErr.Path) != "activate.log" {
+		t.Errorf("expected error of reading activation log, got %v", err)
+	}
+	if _, err = root.FindPackage("lib", "1.0"); err != nil {
+		t.Errorf("dependency which failed removing isn't kept: %v", err)
+	}
+}
+
+func TestConcurrentInstall(t *testing.T) {
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	pkgs := t.TempDir()
+	var paths []string
+	for i := 0; i < 4; i++ {
+		paths = append(paths, writePackage(t, pkgs, fmt.Sprintf("pkg%d", i), "1.0", nil))
+	}
+	// The same package installed several times must be installed only once
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*len(paths))
+	for i := 0; i < 3; i++ {
+		for _, path := range paths {
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				errs <- root.InstallPackage(path, false)
+			}(path)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	failed := 0
+	for err := range errs {
+		if errors.Is(err, ipkg.ErrAlreadyInstalled) {
+			failed++
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+	if failed != 2*len(paths) {
+		t.Errorf("expected %d failed installations, got %d", 2*len(paths), failed)
+	}
+	installed, err := root.Packages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != len(paths) {
+		t.Errorf("expected %d installed packages, got %d", len(paths), len(installed))
+	}
+	// Removing and activating concurrently
+	for i := range paths {
+		wg.Add(2)
+		name := fmt.Sprintf("pkg%d", i)
+		go func() {
+			defer wg.Done()
+			if err := root.ActivatePackage(name, "1.0"); err != nil && !errors.Is(err, ipkg.ErrNotInstalled) {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := root.RemovePackage(name, "1.0", true); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if installed, _ = root.Packages(); len(installed) != 0 {
+		t.Errorf("packages weren't removed: %v", installed)
+	}
+}
+
+func TestInstallWhileRemovingOldDependency(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		root, err := ipkg.CreateRoot(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		if err = root.InstallPackage(writePackage(t, dir, "lib", "1.0", nil), true); err != nil {
+			t.Fatal(err)
+		}
+		// Only the oldest version requires lib, so lib is removed with it
+		for version := 1; version <= 5; version++ {
+			var dependencies map[string]bool
+			if version == 1 {
+				dependencies = map[string]bool{"lib-$1.0": true}
+			}
+			if err = root.InstallPackage(writePackage(t, dir, "tool", fmt.Sprintf("%d.0", version), dependencies), false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		newest := writePackage(t, dir, "tool", "6.0", nil)
+		app := writePackage(t, dir, "app", "1.0", map[string]bool{"lib-$1.0": true})
+		var wg sync.WaitGroup
+		var appErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := root.InstallPackage(newest, false); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			appErr = root.InstallPackage(app, false)
+		}()
+		wg.Wait()
+		// Either app is installed before lib is checked for removing, or lib is removed before app is checked
+		var missingErr *ipkg.MissingDependenciesError
+		if appErr != nil && !errors.As(appErr, &missingErr) {
+			t.Fatal(appErr)
+		}
+		problems, err := root.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 0 {
+			t.Errorf("root is inconsistent: %v", problems)
+		}
+		if _, err = root.FindPackage("tool", "1.0"); err != sql.ErrNoRows {
+			t.Errorf("the oldest version isn't removed: %v", err)
+		}
+		root.Close()
+	}
+}
+
+func TestConcurrentInstallRelativePaths(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cmdlin runs only on Linux")
+	}
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 6; i++ {
+		path := writePackage(t, dir, fmt.Sprintf("pkg%d", i), "1.0", nil)
+		// Command changes working directory of the process while it runs
+		script, err := os.ReadFile(filepath.Join(path, ".ira", "iscript"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		script = append(script, "\ncmdlin \"true\"\n"...)
+		if err = os.WriteFile(filepath.Join(path, ".ira", "iscript"), script, 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.Base(path))
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			if err := root.InstallPackage(path, false); err != nil {
+				t.Error(err)
+			}
+		}(path)
+	}
+	wg.Wait()
+	if installed, _ := root.Packages(); len(installed) != len(paths) {
+		t.Errorf("expected %d installed packages, got %d", len(paths), len(installed))
+	}
+}
+
+// writePackage creates unpacked package name-$version in dir and returns path to it
+func writePackage(t *testing.T, dir, name, version string, dependencies map[string]bool) string {
+	t.Helper()
+	path := filepath.Join(dir, ipkg.PackageID(name, version))
+	if err := os.MkdirAll(filepath.Join(path, ".ira"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	config, err := json.Marshal(ipkg.PkgConfig{
+		Name:           name,
+		Version:        version,
+		Dependencies:   dependencies,
+		SupportWindows: true,
+		SupportLinux:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		".ira/config.json": string(config),
+		".ira/iscript":     "flag install\ninstall 777 \"/bin/" + name + "\" \"/" + name + "\"",
+		name:               "#!/bin/sh\necho " + name + "\n",
+	}
+	for file, content := range files {
+		if err = os.WriteFile(filepath.Join(path, file), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestInstallCleansTemporaryFiles(t *testing.T) {
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	if err = root.InstallPackage("./test/pkgs/testpkg.ipkg", false); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("temporary files weren't removed: %d entries left", len(entries))
+	}
+}
+
+func TestInstallCancelled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build scripts are shell scripts")
+	}
+	rootPath := t.TempDir()
+	root, err := ipkg.CreateRoot(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	// Cancelling while build script is running
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	path := writeBuildPackage(t, "cancelled", "sleep 10")
+	err = root.InstallPackageContext(ctx, path, ipkg.InstallOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation error, got %v", err)
+	}
+	if _, err = root.FindPackage("cancelled", "1.0"); err != sql.ErrNoRows {
+		t.Errorf("cancelled package is in database: %v", err)
+	}
+	if osextra.Exists(filepath.Join(rootPath, "cancelled-$1.0")) {
+		t.Error("cancelled package was installed")
+	}
+	// Cancelled context stops extraction
+	err = root.InstallPackageContext(ctx, "./test/pkgs/testpkg.ipkg", ipkg.InstallOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation error, got %v", err)
+	}
+	if osextra.Exists(filepath.Join(rootPath, "testpkg-$1.0")) {
+		t.Error("cancelled package was installed")
+	}
+}
+
+func TestInstallErrors(t *testing.T) {
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	var missingErr *ipkg.MissingDependenciesError
+	err = root.InstallPackage("./test/pkgs/deppkg", false)
+	if !errors.As(err, &missingErr) {
+		t.Errorf("expected MissingDependenciesError, got %v", err)
+	} else if len(missingErr.Missing) != 1 || missingErr.Missing[0] != "testpkg-$1.0" {
+		t.Errorf("wrong missing dependencies: %v", missingErr.Missing)
+	}
+	if err = root.InstallPackage("./test/pkgs/testpkg", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage("./test/pkgs/testpkg", false); !errors.Is(err, ipkg.ErrAlreadyInstalled) {
+		t.Errorf("expected ErrAlreadyInstalled, got %v", err)
+	}
+	if err = root.InstallPackage("./test/pkgs/testpkg/launch.sh", false); !errors.Is(err, ipkg.ErrNotAPackage) {
+		t.Errorf("expected ErrNotAPackage, got %v", err)
+	}
+	if err = root.RemovePackage("deppkg", "1.0", false); !errors.Is(err, ipkg.ErrNotInstalled) {
+		t.Errorf("expected ErrNotInstalled, got %v", err)
+	}
+	// Package supporting no platforms
+	path := writePackage(t, t.TempDir(), "noplatform", "1.0", nil)
+	config, _ := json.Marshal(ipkg.PkgConfig{Name: "noplatform", Version: "1.0"})
+	if err = os.WriteFile(filepath.Join(path, ".ira", "config.json"), config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackage(path, false); !errors.Is(err, ipkg.ErrUnsupportedPlatform) {
+		t.Errorf("expected ErrUnsupportedPlatform, got %v", err)
+	}
+}
+
+func TestDependencyReport(t *testing.T) {
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir := t.TempDir()
+	for _, version := range []string{"2.0", "10.0", "0.9"} {
+		if err = root.InstallPackage(writePackage(t, dir, "liba", version, nil), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := &ipkg.PkgConfig{Name: "app", Version: "1.0", Dependencies: map[string]bool{
+		"liba-$1.0": true,
+		"libb-$1.0": true,
+		"libc-$1.0": false,
+		"liba-$0.9": false,
+	}}
+	report, err := config.DependencyReport(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Satisfied() {
+		t.Error("report with missing dependencies is satisfied")
+	}
+	if strings.Join(report.Missing, " ") != "liba-$1.0 libb-$1.0" {
+		t.Errorf("wrong missing dependencies: %v", report.Missing)
+	}
+	if strings.Join(report.MissingOptional, " ") != "libc-$1.0" {
+		t.Errorf("wrong missing optional dependencies: %v", report.MissingOptional)
+	}
+	// Versions are ordered by numbers, not lexically
+	if strings.Join(report.Rejected["liba-$1.0"], " ") != "0.9 2.0 10.0" || len(report.Rejected) != 1 {
+		t.Errorf("wrong rejected versions: %v", report.Rejected)
+	}
+	// Installation fails with all missing dependencies in one error
+	path := writePackage(t, dir, "app", "1.0", config.Dependencies)
+	var missingErr *ipkg.MissingDependenciesError
+	if err = root.InstallPackage(path, false); !errors.As(err, &missingErr) {
+		t.Fatalf("expected MissingDependenciesError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "liba-$1.0 (installed versions: 0.9, 2.0, 10.0), libb-$1.0") {
+		t.Errorf("error doesn't describe missing dependencies: %v", err)
+	}
+}
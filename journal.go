@@ -0,0 +1,204 @@
+package ipkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Operation kinds written in journal
+const (
+	OpInstall  = "install"
+	OpRemove   = "remove"
+	OpActivate = "activate"
+)
+
+// Steps of operations. Each step is written in journal before it starts
+const (
+	stepInstallFiles = "install-files" // creating installation folder and running IScript
+	stepRegister     = "register"      // adding package in database
+	stepActivate     = "activate"      // activating installed package
+	stepDeactivate   = "deactivate"    // deactivating removed package
+	stepRemoveFiles  = "remove-files"  // package is not in database, removing its files
+	stepSwitch       = "switch"        // activating package and deactivating its other versions
+)
+
+// Operation is an operation on package root written in journal
+type Operation struct {
+	Kind    string    `json:"kind"` // OpInstall, OpRemove or OpActivate
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	Step    string    `json:"step"` // last started step
+	Started time.Time `json:"started"`
+
+	journal string // path to journal file
+}
+
+// journalDir returns path to directory where in-progress operations are written
+func (r *Root) journalDir() string {
+	return filepath.Join(r.path, "journal")
+}
+
+// beginOperation writes new operation in journal. Operation must be finished by finish()
+func (r *Root) beginOperation(kind, name, version, step string) (*Operation, error) {
+	err := os.MkdirAll(r.journalDir(), os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("creating journal: %v", err)
+	}
+	op := &Operation{
+		Kind:    kind,
+		Name:    name,
+		Version: version,
+		Step:    step,
+		Started: time.Now(),
+		journal: filepath.Join(r.journalDir(), kind+"-"+PackageID(name, version)+".json"),
+	}
+	return op, op.write()
+}
+
+// advance writes in journal that operation started next step
+func (op *Operation) advance(step string) error {
+	op.Step = step
+	return op.write()
+}
+
+// finish removes operation from journal
+func (op *Operation) finish() error {
+	err := os.Remove(op.journal)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing %s from journal: %v", op.Kind, err)
+	}
+	return nil
+}
+
+// write saves operation atomically: data is written into temporary file which replaces old one
+func (op *Operation) write() error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("encoding journal entry: %v", err)
+	}
+	tmp := op.journal + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("writing journal: %v", err)
+	}
+	defer file.Close()
+	if _, err = file.Write(data); err != nil {
+		return fmt.Errorf("writing journal: %v", err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("syncing journal: %v", err)
+	}
+	file.Close()
+	if err = os.Rename(tmp, op.journal); err != nil {
+		return fmt.Errorf("writing journal: %v", err)
+	}
+	return nil
+}
+
+// Recover completes or reverts operations which were interrupted (e.g. process was killed).
+// Installation is completed if package was added in database, otherwise it is reverted.
+// Removing and activation are always completed. Operations which can't be completed (e.g. activation links conflict
+// with other files) are logged and left in journal.
+// Returns recovered operations. Operations recovered automatically by OpenRoot are returned
+// by the first call of Recover.
+func (r *Root) Recover() ([]Operation, error) {
+	unlock, err := r.lockAll()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	recovered := r.recovered
+	r.recovered = nil
+	entries, err := os.ReadDir(r.journalDir())
+	if os.IsNotExist(err) {
+		return recovered, nil
+	} else if err != nil {
+		return recovered, fmt.Errorf("reading journal: %v", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(r.journalDir(), entry.Name())
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			// Entry wasn't written completely, so previous version is still actual
+			os.Remove(path)
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return recovered, fmt.Errorf("reading journal: %v", err)
+		}
+		op := new(Operation)
+		if err = json.Unmarshal(data, op); err != nil {
+			r.logger.Error("journal entry can't be parsed", "entry", entry.Name(), "error", err)
+			continue
+		}
+		op.journal = path
+		logger := r.pkgLogger(op.Name, op.Version)
+		logger.Warn("recovering interrupted operation", "operation", op.Kind, "step", op.Step)
+		if err = r.recoverOperation(op); err != nil {
+			// Entry is kept in journal, so it can be recovered after user fixes the problem
+			logger.Error("interrupted operation can't be recovered", "operation", op.Kind, "error", err)
+			continue
+		}
+		recovered = append(recovered, *op)
+	}
+	return recovered, nil
+}
+
+func (r *Root) recoverOperation(op *Operation) error {
+	_, err := r.FindPackage(op.Name, op.Version)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	registered := err == nil
+	switch op.Kind {
+	case OpInstall:
+		if !registered {
+			err = r.revertInstallation(op.Name, op.Version)
+		} else {
+			// Package could be added in database before journal was updated
+			err = r.activatePackage(op.Name, op.Version)
+			if err == nil {
+				err = r.removeOld(op.Name)
+			}
+		}
+	case OpRemove:
+		if registered {
+			err = r.removePackage(op.Name, op.Version, false, false)
+		} else {
+			err = os.RemoveAll(filepath.Join(r.path, PackageID(op.Name, op.Version)))
+			if err == nil {
+				err = r.removeCache(op.Name, op.Version)
+			}
+		}
+	case OpActivate:
+		if registered {
+			err = r.activatePackage(op.Name, op.Version)
+		}
+	default:
+		err = fmt.Errorf("unknown operation %q", op.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	return op.finish()
+}
+
+// revertInstallation removes files of package which wasn't added in database
+func (r *Root) revertInstallation(name, version string) error {
+	path := filepath.Join(r.path, PackageID(name, version))
+	// IScript of older ipkg could already create activation links
+	err := r.removeActivationLinks(OpInstall, name, version)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing package files: %v", err)
+	}
+	return r.removeCache(name, version)
+}
@@ -0,0 +1,114 @@
+package ipkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	osextra "github.com/ira-package-manager/gobetter/os_extra"
+)
+
+func TestRecoverInterruptedInstall(t *testing.T) {
+	path := t.TempDir()
+	root, err := CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	// Process was killed after IScript but before adding package in database
+	installDir := filepath.Join(path, PackageID("broken", "1.0"))
+	if err = os.MkdirAll(filepath.Join(installDir, ".ira"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = root.beginOperation(OpInstall, "broken", "1.0", stepRegister); err != nil {
+		t.Fatal(err)
+	}
+	// Process was killed after adding package in database but before activation
+	config := &PkgConfig{Name: "complete", Version: "1.0"}
+	if err = os.MkdirAll(filepath.Join(path, PackageID("complete", "1.0"), ".ira"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.registerPackage(context.Background(), config, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = root.beginOperation(OpInstall, "complete", "1.0", stepRegister); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err = OpenRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	recovered, err := root.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 2 {
+		t.Errorf("expected 2 recovered operations, got %d", len(recovered))
+	}
+	if osextra.Exists(installDir) {
+		t.Error("unregistered installation wasn't reverted")
+	}
+	if _, err = root.FindPackage("complete", "1.0"); err != nil {
+		t.Errorf("registered installation wasn't completed: %v", err)
+	}
+	if entries, _ := os.ReadDir(root.journalDir()); len(entries) != 0 {
+		t.Errorf("journal isn't empty: %d entries", len(entries))
+	}
+}
+
+func TestRecoverFailedActivation(t *testing.T) {
+	path := t.TempDir()
+	root, err := CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	// Package was registered, but its link conflicts with file created by user, so it can't be activated
+	installDir := filepath.Join(path, PackageID("conflict", "1.0"))
+	if err = os.MkdirAll(filepath.Join(installDir, ".ira"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	taken := filepath.Join(t.TempDir(), "tool")
+	if err = os.WriteFile(taken, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeActivationLog(installDir, []activationLink{{Target: "tool", Link: taken}}); err != nil {
+		t.Fatal(err)
+	}
+	config := &PkgConfig{Name: "conflict", Version: "1.0"}
+	if err = root.registerPackage(context.Background(), config, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = root.beginOperation(OpInstall, "conflict", "1.0", stepActivate); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err = OpenRoot(path)
+	if err != nil {
+		t.Fatalf("root isn't opened because of failed recovery: %v", err)
+	}
+	defer root.Close()
+	recovered, err := root.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 0 {
+		t.Errorf("failed operation is reported as recovered: %+v", recovered)
+	}
+	// Entry is kept, so operation can be recovered after conflict is resolved
+	if entries, _ := os.ReadDir(root.journalDir()); len(entries) != 1 {
+		t.Errorf("expected 1 journal entry, got %d", len(entries))
+	}
+	if err = os.Remove(taken); err != nil {
+		t.Fatal(err)
+	}
+	if recovered, err = root.Recover(); err != nil || len(recovered) != 1 {
+		t.Errorf("operation isn't recovered after resolving conflict: %v, %+v", err, recovered)
+	}
+	if !root.IsActive("conflict", "1.0") {
+		t.Error("package isn't activated by recovery")
+	}
+}
@@ -0,0 +1,228 @@
+package ipkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errWouldBlock is returned by lockFile when file is locked by another process
+var errWouldBlock = errors.New("lock is held by another process")
+
+// LockedError is returned when package root is locked by another process longer than lock timeout
+type LockedError struct {
+	Path string
+	PID  int // process holding the lock, 0 if unknown
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("package root %s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("package root %s is locked by process %d", e.Path, e.PID)
+}
+
+// rootLock is an advisory lock of package root shared with other processes.
+// Mutating operations hold it exclusively, read-only ones hold it shared.
+// Lock is reference counted, so operations started inside other operations reuse it.
+type rootLock struct {
+	path    string
+	timeout time.Duration // 0 means failing immediately, negative value means waiting forever
+
+	mu      sync.Mutex
+	file    *os.File
+	readers int
+	writers int
+}
+
+func newRootLock(rootPath string, timeout time.Duration) *rootLock {
+	return &rootLock{
+		path:    filepath.Join(rootPath, ".lock"),
+		timeout: timeout,
+	}
+}
+
+// lock acquires lock. If lock is already held by this root in the suitable mode, only counter is increased
+func (l *rootLock) lock(exclusive bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.writers == 0 && (exclusive || l.readers == 0) {
+		err := l.acquire(exclusive)
+		if err != nil {
+			return err
+		}
+	}
+	if exclusive {
+		l.writers++
+	} else {
+		l.readers++
+	}
+	return nil
+}
+
+// unlock releases lock taken by lock(exclusive)
+func (l *rootLock) unlock(exclusive bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if exclusive {
+		l.writers--
+	} else {
+		l.readers--
+	}
+	if l.readers == 0 && l.writers == 0 {
+		// Note: ignoring errors, closing file releases lock anyway
+		unlockFile(l.file)
+		l.file.Close()
+		l.file = nil
+	} else if exclusive && l.writers == 0 {
+		// Readers are still working, converting lock to shared.
+		// Note: ignoring errors, shared lock can't be held by writer of another process while we hold exclusive one
+		lockFile(l.file, false)
+	}
+}
+
+// acquire takes lock of file waiting for l.timeout
+func (l *rootLock) acquire(exclusive bool) error {
+	if l.file == nil {
+		file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("opening lock file: %v", err)
+		}
+		l.file = file
+	}
+	deadline := time.Now().Add(l.timeout)
+	for {
+		err := lockFile(l.file, exclusive)
+		if err == nil {
+			break
+		}
+		if err != errWouldBlock {
+			l.abort()
+			return fmt.Errorf("locking package root: %v", err)
+		}
+		if l.timeout >= 0 && time.Now().After(deadline) {
+			pid := l.holder()
+			l.abort()
+			return &LockedError{Path: filepath.Dir(l.path), PID: pid}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Writing our PID, so other processes can report who holds the lock
+	if err := l.file.Truncate(0); err == nil {
+		l.file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	return nil
+}
+
+// abort restores state of lock after failed acquire
+func (l *rootLock) abort() {
+	if l.readers == 0 && l.writers == 0 {
+		l.file.Close()
+		l.file = nil
+	} else {
+		// Converting shared lock to exclusive could release shared one
+		lockFile(l.file, false)
+	}
+}
+
+// holder returns PID written in lock file by process holding lock
+func (l *rootLock) holder() int {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// packageLocks serializes operations on packages with the same name inside the process
+type packageLocks struct {
+	mu    sync.Mutex
+	locks map[string]*packageLock
+}
+
+type packageLock struct {
+	sync.Mutex
+	refs int // number of goroutines holding or waiting for lock
+}
+
+func (l *packageLocks) lock(name string) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*packageLock)
+	}
+	pkgLock, ok := l.locks[name]
+	if !ok {
+		pkgLock = new(packageLock)
+		l.locks[name] = pkgLock
+	}
+	pkgLock.refs++
+	l.mu.Unlock()
+	pkgLock.Lock()
+}
+
+func (l *packageLocks) unlock(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pkgLock := l.locks[name]
+	pkgLock.Unlock()
+	pkgLock.refs--
+	if pkgLock.refs == 0 {
+		delete(l.locks, name)
+	}
+}
+
+// lockPackage locks root exclusively for other processes and serializes operations
+// on packages named name inside the process. Returned function releases locks
+func (r *Root) lockPackage(name string) (func(), error) {
+	return r.lockPackages(name)
+}
+
+// lockPackages works as lockPackage for several names. Names are locked in sorted order,
+// so operations locking intersecting sets of packages don't deadlock
+func (r *Root) lockPackages(names ...string) (func(), error) {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	var unique []string
+	for _, name := range sorted {
+		if len(unique) == 0 || unique[len(unique)-1] != name {
+			unique = append(unique, name)
+		}
+	}
+	if err := r.lock.lock(true); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	for _, name := range unique {
+		r.pkgLocks.lock(name)
+	}
+	return func() {
+		for i := len(unique) - 1; i >= 0; i-- {
+			r.pkgLocks.unlock(unique[i])
+		}
+		r.mu.RUnlock()
+		r.lock.unlock(true)
+	}, nil
+}
+
+// lockAll locks root exclusively for other processes and for all other operations of the process.
+// It is used by operations touching several packages. Returned function releases locks
+func (r *Root) lockAll() (func(), error) {
+	if err := r.lock.lock(true); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	return func() {
+		r.mu.Unlock()
+		r.lock.unlock(true)
+	}, nil
+}
@@ -0,0 +1,54 @@
+package ipkg
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRootLock(t *testing.T) {
+	path := t.TempDir()
+	holder, err := CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	root, err := OpenRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	// Shared locks don't conflict
+	if err = holder.lock.lock(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = root.Packages(); err != nil {
+		t.Errorf("read-only query failed while root is shared: %v", err)
+	}
+	var lockedErr *LockedError
+	err = root.MarkAsUserInstalled("testpkg", "1.0")
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("expected LockedError, got %v", err)
+	}
+	if lockedErr.PID != os.Getpid() {
+		t.Errorf("wrong PID of lock holder: got %d, expected %d", lockedErr.PID, os.Getpid())
+	}
+	holder.lock.unlock(false)
+
+	// Exclusive lock blocks everyone until it is released
+	if err = holder.lock.lock(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = root.Packages(); !errors.As(err, &lockedErr) {
+		t.Errorf("expected LockedError, got %v", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		holder.lock.unlock(true)
+	}()
+	WithLockTimeout(5 * time.Second)(root)
+	if _, err = root.Packages(); err != nil {
+		t.Errorf("waiting for lock: %v", err)
+	}
+}
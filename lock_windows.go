@@ -0,0 +1,32 @@
+//go:build windows
+
+package ipkg
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Locked region is placed far from file content, because locks on Windows are mandatory
+// and PID written in file must stay readable
+const lockOffset = 0x7FFFFFFF
+
+// lockFile takes LockFileEx of file without blocking.
+// Windows can't convert locks, so previous lock is released first
+func lockFile(file *os.File, exclusive bool) error {
+	unlockFile(file)
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &windows.Overlapped{Offset: lockOffset})
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return errWouldBlock
+	}
+	return err
+}
+
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &windows.Overlapped{Offset: lockOffset})
+}
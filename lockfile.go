@@ -0,0 +1,332 @@
+package ipkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// packageChecksum returns SHA-256 checksum of package in path.
+// Checksum of IPKG archive is checksum of the file, checksum of directory is computed from paths and contents of all its files
+func packageChecksum(path string) (string, error) {
+	hash := sha256.New()
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		if _, err = io.Copy(hash, file); err != nil {
+			return "", fmt.Errorf("computing checksum of %s: %v", path, err)
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	// WalkDir visits files in lexical order, so checksum doesn't depend on file system
+	err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "link %s %s\x00", filepath.ToSlash(rel), target)
+		case d.Type().IsRegular():
+			fmt.Fprintf(hash, "file %s\x00", filepath.ToSlash(rel))
+			content, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer content.Close()
+			if _, err = io.Copy(hash, content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("computing checksum of %s: %v", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// LockedPackage is a package recorded in lockfile
+type LockedPackage struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Checksum string `json:"checksum"` // empty if package was installed before checksums were recorded
+	ByUser   bool   `json:"by_user"`
+	Active   bool   `json:"active"`
+}
+
+// Lockfile describes all packages of root, so the same root can be reproduced on another machine
+type Lockfile struct {
+	Packages []LockedPackage `json:"packages"` // sorted by name and version
+}
+
+// ReadLockfile reads lockfile in JSON format
+func ReadLockfile(r io.Reader) (*Lockfile, error) {
+	lockfile := new(Lockfile)
+	if err := json.NewDecoder(r).Decode(lockfile); err != nil {
+		return nil, fmt.Errorf("reading lockfile: %v", err)
+	}
+	for _, pkg := range lockfile.Packages {
+		if pkg.Name == "" || pkg.Version == "" {
+			return nil, fmt.Errorf("reading lockfile: package without name or version")
+		}
+	}
+	return lockfile, nil
+}
+
+// Write writes lockfile in JSON format
+func (lockfile *Lockfile) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(lockfile)
+}
+
+// ExportLockfile returns lockfile of all packages installed in root
+func (r *Root) ExportLockfile() (*Lockfile, error) {
+	pkgs, err := r.lockedPackages()
+	if err != nil {
+		return nil, err
+	}
+	lockfile := &Lockfile{Packages: make([]LockedPackage, 0, len(pkgs))}
+	for _, pkg := range pkgs {
+		lockfile.Packages = append(lockfile.Packages, pkg)
+	}
+	sort.Slice(lockfile.Packages, func(i, j int) bool {
+		a, b := lockfile.Packages[i], lockfile.Packages[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return lockfile, nil
+}
+
+// lockedPackages returns all installed packages by IDs
+func (r *Root) lockedPackages() (map[string]LockedPackage, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	rows, err := r.db.Query("SELECT name, version, checksum, by_user FROM packages")
+	if err != nil {
+		return nil, fmt.Errorf("in lockedPackages: %v", err)
+	}
+	defer rows.Close()
+	result := make(map[string]LockedPackage)
+	for rows.Next() {
+		var pkg LockedPackage
+		var byUser int
+		if err = rows.Scan(&pkg.Name, &pkg.Version, &pkg.Checksum, &byUser); err != nil {
+			return nil, fmt.Errorf("in lockedPackages: %v", err)
+		}
+		pkg.ByUser = byUser == 1
+		result[PackageID(pkg.Name, pkg.Version)] = pkg
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("in lockedPackages: %v", err)
+	}
+	rows.Close()
+	for id, pkg := range result {
+		pkg.Active = r.IsActive(pkg.Name, pkg.Version)
+		result[id] = pkg
+	}
+	return result, nil
+}
+
+// ChecksumError is returned by ApplyLockfile when checksum of package differs from checksum in lockfile
+type ChecksumError struct {
+	Path     string // path to package, empty if package is already installed
+	Name     string
+	Version  string
+	Checksum string
+	Expected string // checksum from lockfile
+}
+
+func (e *ChecksumError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("installed package %s has checksum %s, lockfile requires %s", PackageID(e.Name, e.Version), e.Checksum, e.Expected)
+	}
+	return fmt.Sprintf("package %s has checksum %s, lockfile requires %s", e.Path, e.Checksum, e.Expected)
+}
+
+// ApplyLockfile changes root to match lockfile exactly: packages missing in lockfile are removed,
+// missing packages are installed from sources (see InstallOptions.Sources), then installation reasons
+// and active versions are set as in lockfile. Packages are installed only if their checksums match lockfile.
+// Installed package with another checksum isn't reinstalled, because it can be a build user relies on:
+// ChecksumError is returned before anything is changed, package must be removed to apply lockfile.
+// Every change is a separate operation, so if applying fails, root is left partially changed
+// and lockfile can be applied again.
+func (r *Root) ApplyLockfile(ctx context.Context, lockfile *Lockfile, sources []string) error {
+	wanted := make(map[string]LockedPackage)
+	for _, pkg := range lockfile.Packages {
+		wanted[PackageID(pkg.Name, pkg.Version)] = pkg
+	}
+	installed, err := r.lockedPackages()
+	if err != nil {
+		return err
+	}
+	// Installed packages must be the same as locked ones
+	for id, pkg := range installed {
+		if locked, ok := wanted[id]; ok && pkg.Checksum != "" && locked.Checksum != "" && pkg.Checksum != locked.Checksum {
+			return &ChecksumError{Name: pkg.Name, Version: pkg.Version, Checksum: pkg.Checksum, Expected: locked.Checksum}
+		}
+	}
+
+	// Removing packages missing in lockfile. Packages required by other removed packages are removed after them
+	var extra []LockedPackage
+	for id, pkg := range installed {
+		if _, ok := wanted[id]; !ok {
+			extra = append(extra, pkg)
+		}
+	}
+	err = retryInOrder(ctx, extra, func(pkg LockedPackage) error {
+		err := r.RemovePackageContext(ctx, pkg.Name, pkg.Version, RemoveOptions{})
+		if errors.Is(err, ErrNotInstalled) {
+			return nil
+		}
+		var dependentsErr *DependentsError
+		if errors.As(err, &dependentsErr) {
+			return errRetryLater
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Installing missing packages. Dependencies are installed before packages requiring them
+	var missing []LockedPackage
+	for id, pkg := range wanted {
+		if _, ok := installed[id]; !ok {
+			missing = append(missing, pkg)
+		}
+	}
+	err = retryInOrder(ctx, missing, func(pkg LockedPackage) error {
+		id := PackageID(pkg.Name, pkg.Version)
+		path := findInSources(sources, id)
+		if path == "" {
+			return fmt.Errorf("package %s isn't found in sources", id)
+		}
+		checksum, err := packageChecksum(path)
+		if err != nil {
+			return err
+		}
+		if pkg.Checksum != "" && checksum != pkg.Checksum {
+			return &ChecksumError{Path: path, Name: pkg.Name, Version: pkg.Version, Checksum: checksum, Expected: pkg.Checksum}
+		}
+		err = r.InstallPackageContext(ctx, path, InstallOptions{AsDependency: !pkg.ByUser})
+		var missingErr *MissingDependenciesError
+		if errors.As(err, &missingErr) {
+			return errRetryLater
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Setting installation reasons and active versions
+	for id, pkg := range wanted {
+		if err = r.setInstalledByUser(pkg.Name, pkg.Version, pkg.ByUser); err != nil {
+			return fmt.Errorf("applying lockfile to %s: %w", id, err)
+		}
+		if pkg.Active && !r.IsActive(pkg.Name, pkg.Version) {
+			err = r.ActivatePackage(pkg.Name, pkg.Version)
+		} else if !pkg.Active && r.IsActive(pkg.Name, pkg.Version) {
+			err = r.deactivatePackage(pkg.Name, pkg.Version)
+		}
+		if err != nil {
+			return fmt.Errorf("applying lockfile to %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// deactivatePackage deactivates package name-$version, so no its version is active
+func (r *Root) deactivatePackage(name, version string) error {
+	unlock, err := r.lockPackage(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = r.deactivate(OpActivate, name, version); err != nil {
+		return err
+	}
+	r.pkgLogger(name, version).Info("package deactivated")
+	return nil
+}
+
+// setInstalledByUser marks package as installed by user or as dependency
+func (r *Root) setInstalledByUser(name, version string, byUser bool) error {
+	unlock, err := r.lockPackage(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	value := 0
+	if byUser {
+		value = 1
+	}
+	result, err := r.db.Exec("UPDATE packages SET by_user = ? WHERE name = ? AND version = ?", value, name, version)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("package %s-$%s is %w", name, version, ErrNotInstalled)
+	}
+	return nil
+}
+
+// errRetryLater is returned by action of retryInOrder when package must be processed after other ones
+var errRetryLater = errors.New("retry later")
+
+// retryInOrder runs action for every package. Packages which action returned errRetryLater for
+// are tried again after other ones, until all packages are processed or no progress is made
+func retryInOrder(ctx context.Context, pkgs []LockedPackage, action func(LockedPackage) error) error {
+	for len(pkgs) != 0 {
+		var postponed []LockedPackage
+		for _, pkg := range pkgs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			err := action(pkg)
+			if err == errRetryLater {
+				postponed = append(postponed, pkg)
+			} else if err != nil {
+				return err
+			}
+		}
+		if len(postponed) == len(pkgs) {
+			ids := make([]string, len(postponed))
+			for i, pkg := range postponed {
+				ids[i] = PackageID(pkg.Name, pkg.Version)
+			}
+			sort.Strings(ids)
+			return fmt.Errorf("can't order packages %v: dependencies are missing in lockfile", ids)
+		}
+		pkgs = postponed
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package ipkg_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestLockfile(t *testing.T) {
+	dir := t.TempDir()
+	source, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	packages := []struct {
+		name, version string
+		dependencies  map[string]bool
+		asDependency  bool
+	}{
+		{"lib", "1.0", nil, true},
+		{"app", "1.0", map[string]bool{"lib-$1.0": true}, false},
+		{"tool", "2.0", nil, false},
+		{"tool", "1.0", nil, false},
+	}
+	for _, pkg := range packages {
+		if err = source.InstallPackage(writePackage(t, dir, pkg.name, pkg.version, pkg.dependencies), pkg.asDependency); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = source.ActivatePackage("tool", "2.0"); err != nil {
+		t.Fatal(err)
+	}
+	lockfile, err := source.ExportLockfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = lockfile.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if lockfile, err = ipkg.ReadLockfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(lockfile.Packages) != 4 || lockfile.Packages[0].Name != "app" || lockfile.Packages[0].Checksum == "" {
+		t.Fatalf("wrong lockfile: %+v", lockfile.Packages)
+	}
+
+	// Root with other packages is changed to match lockfile
+	target, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	otherDir := t.TempDir()
+	if err = target.InstallPackage(writePackage(t, otherDir, "base", "1.0", nil), true); err != nil {
+		t.Fatal(err)
+	}
+	if err = target.InstallPackage(writePackage(t, otherDir, "stale", "1.0", map[string]bool{"base-$1.0": true}), false); err != nil {
+		t.Fatal(err)
+	}
+	if err = target.InstallPackage(filepath.Join(dir, ipkg.PackageID("lib", "1.0")), false); err != nil {
+		t.Fatal(err)
+	}
+	if err = target.ApplyLockfile(context.Background(), lockfile, []string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := target.ExportLockfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(applied, lockfile) {
+		t.Errorf("root doesn't match lockfile:\n%+v\n%+v", applied.Packages, lockfile.Packages)
+	}
+
+	// Package inactive in lockfile is deactivated
+	inactive := &ipkg.Lockfile{Packages: append([]ipkg.LockedPackage(nil), lockfile.Packages...)}
+	for i, pkg := range inactive.Packages {
+		if pkg.Name == "tool" {
+			inactive.Packages[i].Active = false
+		}
+	}
+	if err = target.ApplyLockfile(context.Background(), inactive, []string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	if target.IsActive("tool", "1.0") || target.IsActive("tool", "2.0") {
+		t.Error("package inactive in lockfile is active")
+	}
+	if !target.IsActive("app", "1.0") {
+		t.Error("package active in lockfile is deactivated")
+	}
+
+	// Installed package with another checksum isn't replaced
+	changed := &ipkg.Lockfile{Packages: append([]ipkg.LockedPackage(nil), lockfile.Packages...)}
+	changed.Packages[0].Checksum = strings.Repeat("0", 64)
+	var checksumErr *ipkg.ChecksumError
+	err = target.ApplyLockfile(context.Background(), changed, []string{dir})
+	if !errors.As(err, &checksumErr) || checksumErr.Path != "" || checksumErr.Name != changed.Packages[0].Name {
+		t.Errorf("expected checksum error of installed package, got %v", err)
+	}
+
+	// Package changed after lockfile was exported isn't installed
+	target, err = ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	if err = os.WriteFile(filepath.Join(dir, ipkg.PackageID("lib", "1.0"), "lib"), []byte("changed"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = target.ApplyLockfile(context.Background(), lockfile, []string{dir})
+	if !errors.As(err, &checksumErr) || checksumErr.Path == "" {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package ipkg
+
+import (
+	"io"
+	"log/slog"
+)
+
+// discardLogger is used when root has no logger
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// WithLogger sets logger receiving records about every step of package operations.
+// By default nothing is logged
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Root) {
+		if logger == nil {
+			logger = discardLogger
+		}
+		r.logger = logger
+	}
+}
+
+// pkgLogger returns logger adding package name and version to records.
+// Name and version aren't added while they are unknown (e.g. archive isn't extracted yet)
+func (r *Root) pkgLogger(name, version string) *slog.Logger {
+	if name == "" {
+		return r.logger
+	}
+	return r.logger.With("name", name, "version", version)
+}
@@ -0,0 +1,43 @@
+package ipkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestStructuredLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	root, err := ipkg.CreateRoot(t.TempDir(), ipkg.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err = root.InstallPackage("./test/pkgs/testpkg", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.RemovePackage("testpkg", "1.0", false); err != nil {
+		t.Fatal(err)
+	}
+	messages := make(map[string]bool)
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record map[string]any
+		if err = decoder.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record["name"] != "testpkg" || record["version"] != "1.0" {
+			t.Errorf("record has no package attributes: %v", record)
+		}
+		messages[record["msg"].(string)] = true
+	}
+	for _, msg := range []string{"installing package", "build script finished", "package installed", "removing package", "package removed"} {
+		if !messages[msg] {
+			t.Errorf("%q wasn't logged", msg)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package ipkg
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	osextra "github.com/ira-package-manager/gobetter/os_extra"
+)
+
+// migration upgrades database of root by one version inside transaction tx
+type migration func(r *Root, tx *sql.Tx) error
+
+// execMigration returns migration running SQL query
+func execMigration(query string) migration {
+	return func(_ *Root, tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// migrations upgrade database of package roots created by older versions.
+// Version of database is stored in user_version pragma, migration i upgrades database from version i to i+1
+var migrations = []migration{
+	// Checksum of package which installation was made from
+	execMigration("ALTER TABLE packages ADD COLUMN checksum TEXT NOT NULL DEFAULT ('')"),
+	// Owners of activation links
+	migrateLinks,
+	// Active versions of packages, which were stored in flag files before
+	migrateActive,
+	// Files put on disk by packages
+	migrateFiles,
+	// Configuration files of packages
+	migrateConfigFiles,
+	// Optional dependencies enabled when package was installed, NULL if they weren't recorded
+	execMigration("ALTER TABLE packages ADD COLUMN optional TEXT"),
+}
+
+// databaseVersion returns version of database schema
+func databaseVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// migrate upgrades database of root to the latest version. Root is locked while database is upgraded
+func (r *Root) migrate(db *sql.DB) error {
+	version, err := databaseVersion(db)
+	if err != nil {
+		return fmt.Errorf("getting database version: %v", err)
+	}
+	if version >= len(migrations) {
+		return nil
+	}
+	if err = r.lock.lock(true); err != nil {
+		return err
+	}
+	defer r.lock.unlock(true)
+	// Database could be upgraded by another process while we were waiting for lock
+	version, err = databaseVersion(db)
+	if err != nil {
+		return fmt.Errorf("getting database version: %v", err)
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err = migrations[version](r, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("upgrading database to version %d: %v", version+1, err)
+		}
+		// PRAGMA doesn't support parameters
+		if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("upgrading database to version %d: %v", version+1, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("upgrading database to version %d: %v", version+1, err)
+		}
+	}
+	return nil
+}
+
+// migrateActive adds columns with activation state and fills them from installation folders.
+// Package is active if its folder exists and it is pointed by current/<name> or, if there is no such link,
+// it has no .ira/deactivated flag. If several versions of package are active, the newest one is chosen
+func migrateActive(r *Root, tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE packages ADD COLUMN active INTEGER NOT NULL DEFAULT (0);
+		ALTER TABLE packages ADD COLUMN activated_at TIMESTAMP;`)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT name, version FROM packages")
+	if err != nil {
+		return err
+	}
+	active := make(map[string][]PkgConfig)
+	for rows.Next() {
+		var pkg PkgConfig
+		if err = rows.Scan(&pkg.Name, &pkg.Version); err != nil {
+			rows.Close()
+			return err
+		}
+		if osextra.Exists(filepath.Join(r.path, PackageID(pkg.Name, pkg.Version))) && r.isActiveInstallation(pkg.Name, pkg.Version) {
+			active[pkg.Name] = append(active[pkg.Name], pkg)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, pkgs := range active {
+		SortByVersion.Sort(pkgs, true)
+		_, err = tx.Exec("UPDATE packages SET active = 1 WHERE name = ? AND version = ?", pkgs[0].Name, pkgs[0].Version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isActiveInstallation checks if installation folder of name-$version was active before activation state was stored in database
+func (r *Root) isActiveInstallation(name, version string) bool {
+	if current, ok := r.activeVersion(name); ok {
+		return current == version
+	}
+	path := filepath.Join(r.path, PackageID(name, version))
+	return !osextra.Exists(filepath.Join(path, ".ira", "deactivated"))
+}
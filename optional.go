@@ -0,0 +1,110 @@
+package ipkg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+
+	osextra "github.com/ira-package-manager/gobetter/os_extra"
+)
+
+// findInSources searches package with ID id in directories sources.
+// Package can be a folder or IPKG archive named as its ID. Returns empty string if package isn't found
+func findInSources(sources []string, id string) string {
+	for _, dir := range sources {
+		for _, path := range []string{filepath.Join(dir, id), filepath.Join(dir, id+".ipkg")} {
+			if osextra.Exists(path) {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// installOptional installs optional dependencies of package which are found in opts.Sources
+// and accepted by opts. Optional dependencies are installed as dependencies with the same options.
+// Optional dependency which can't be installed doesn't fail installation, it is logged only.
+// Returns IDs of installed optional dependencies, so they can be removed if package isn't installed
+func (r *Root) installOptional(ctx context.Context, config *PkgConfig, opts InstallOptions) ([]string, error) {
+	if !opts.WithOptional && opts.AcceptOptional == nil {
+		return nil, nil
+	}
+	report, err := config.DependencyReport(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.installing == nil {
+		opts.installing = make(map[string]bool)
+	}
+	opts.installing[PackageID(config.Name, config.Version)] = true
+	logger := r.pkgLogger(config.Name, config.Version)
+	var installed []string
+	for _, id := range report.MissingOptional {
+		if opts.installing[id] {
+			continue
+		}
+		path := findInSources(opts.Sources, id)
+		if path == "" {
+			logger.Debug("optional dependency isn't available", "dependency", id)
+			continue
+		}
+		if !opts.WithOptional && !opts.AcceptOptional(id) {
+			continue
+		}
+		depOpts := opts
+		depOpts.AsDependency = true
+		err = r.InstallPackageContext(ctx, path, depOpts)
+		if err == nil {
+			installed = append(installed, id)
+		}
+		if ctx.Err() != nil {
+			return installed, ctx.Err()
+		} else if err != nil && !errors.Is(err, ErrAlreadyInstalled) {
+			logger.Warn("optional dependency wasn't installed", "dependency", id, "error", err)
+		}
+	}
+	return installed, nil
+}
+
+// removeOptional removes optional dependencies installed by installOptional for package which wasn't installed.
+// Dependency is kept if another package has required it meanwhile or user has marked it as installed by user
+func (r *Root) removeOptional(config *PkgConfig, installed []string) {
+	logger := r.pkgLogger(config.Name, config.Version)
+	for i := len(installed) - 1; i >= 0; i-- {
+		name, version, err := ParseID(installed[i])
+		if err != nil {
+			continue
+		}
+		isDependency, err := r.IsDependency(name, version)
+		if err != nil || !isDependency {
+			continue
+		}
+		if canBeRemoved, err := r.CanBeRemoved(name, version); err != nil || !canBeRemoved {
+			continue
+		}
+		if err = r.RemovePackage(name, version, true); err != nil {
+			logger.Warn("optional dependency wasn't removed", "dependency", installed[i], "error", err)
+		}
+	}
+}
+
+// enabledOptional returns sorted IDs of optional dependencies of package which are installed, so their features are enabled
+func (r *Root) enabledOptional(config *PkgConfig) ([]string, error) {
+	report, err := config.DependencyReport(r)
+	if err != nil {
+		return nil, err
+	}
+	missing := make(map[string]bool)
+	for _, id := range report.MissingOptional {
+		missing[id] = true
+	}
+	result := []string{}
+	for id, isRequired := range config.Dependencies {
+		if !isRequired && !missing[id] {
+			result = append(result, id)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,143 @@
+package ipkg_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestOptionalDependencies(t *testing.T) {
+	dir := t.TempDir()
+	writePackage(t, dir, "plugin", "1.0", map[string]bool{"app-$1.0": false}) // cyclic optional dependency
+	writePackage(t, dir, "extra", "1.0", nil)
+	app := writePackage(t, dir, "app", "1.0", map[string]bool{
+		"plugin-$1.0":  false,
+		"extra-$1.0":   false,
+		"missing-$1.0": false,
+	})
+
+	// Without WithOptional optional dependencies are offered
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	var offered []string
+	err = root.InstallPackageContext(context.Background(), app, ipkg.InstallOptions{
+		Sources: []string{dir},
+		AcceptOptional: func(id string) bool {
+			offered = append(offered, id)
+			return id == "plugin-$1.0"
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offered) != 2 {
+		t.Errorf("expected offering 2 available dependencies, got %v", offered)
+	}
+	info, err := root.Info("app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Optional["plugin-$1.0"] || info.Optional["extra-$1.0"] || info.Optional["missing-$1.0"] || len(info.Optional) != 3 {
+		t.Errorf("wrong optional features: %v", info.Optional)
+	}
+	if isDependency, _ := root.IsDependency("plugin", "1.0"); !isDependency {
+		t.Error("optional dependency wasn't installed as dependency")
+	}
+
+	// WithOptional installs everything available
+	root, err = ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err = root.InstallPackageContext(context.Background(), app, ipkg.InstallOptions{Sources: []string{dir}, WithOptional: true}); err != nil {
+		t.Fatal(err)
+	}
+	info, err = root.Info("app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled := info.EnabledOptional(); len(enabled) != 2 || enabled[0] != "extra-$1.0" || enabled[1] != "plugin-$1.0" {
+		t.Errorf("wrong enabled features: %v", enabled)
+	}
+}
+
+func TestOptionalDependenciesOrder(t *testing.T) {
+	dir := t.TempDir()
+	writePackage(t, dir, "extra", "1.0", nil)
+	app := writePackage(t, dir, "app", "1.0", map[string]bool{"extra-$1.0": false, "lib-$1.0": true})
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	opts := ipkg.InstallOptions{Sources: []string{dir}, WithOptional: true}
+
+	// Package which can't be installed doesn't install its optional dependencies
+	var missingErr *ipkg.MissingDependenciesError
+	if err = root.InstallPackageContext(context.Background(), app, opts); !errors.As(err, &missingErr) {
+		t.Fatalf("expected MissingDependenciesError, got %v", err)
+	}
+	if _, err = root.FindPackage("extra", "1.0"); err != sql.ErrNoRows {
+		t.Errorf("optional dependency of failed package was installed: %v", err)
+	}
+
+	// Enabled features are recorded on installation and don't change when dependency is removed
+	if err = root.InstallPackage(writePackage(t, t.TempDir(), "lib", "1.0", nil), true); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.InstallPackageContext(context.Background(), app, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err = root.RemovePackage("extra", "1.0", false); err != nil {
+		t.Fatal(err)
+	}
+	info, err := root.Info("app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled := info.EnabledOptional(); len(enabled) != 1 || enabled[0] != "extra-$1.0" {
+		t.Errorf("wrong enabled features: %v", enabled)
+	}
+}
+
+func TestOptionalDependenciesRemovedOnFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build scripts are shell scripts")
+	}
+	dir := t.TempDir()
+	writePackage(t, dir, "extra", "1.0", nil)
+	app := writeBuildPackage(t, "app", "exit 1")
+	config, err := json.Marshal(ipkg.PkgConfig{
+		Name: "app", Version: "1.0", SupportLinux: true, SupportWindows: true, Build: true,
+		Dependencies: map[string]bool{"extra-$1.0": false},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(app, ".ira", "config.json"), config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	err = root.InstallPackageContext(context.Background(), app, ipkg.InstallOptions{Sources: []string{dir}, WithOptional: true})
+	if err == nil {
+		t.Fatal("package with failing build script was installed")
+	}
+	if _, err = root.FindPackage("extra", "1.0"); err != sql.ErrNoRows {
+		t.Errorf("optional dependency of not installed package is left: %v", err)
+	}
+}
@@ -2,25 +2,54 @@ package ipkg
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
-	osextra "github.com/ira-package-manager/gobetter/os_extra"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// Root is a place where all packages install
+// Root is a place where all packages install.
+// Operations of Root lock package root, so several processes can work with the same root:
+// mutating operations wait for exclusive lock, read-only queries take shared one.
+// Root is safe for concurrent use by multiple goroutines: operations on packages with the same name
+// are serialized, operations touching several packages (removing, recovering) run exclusively.
+// Root must be closed by Close when it isn't needed anymore.
 type Root struct {
-	path string
-	db   *sql.DB
+	path      string
+	db        *sql.DB
+	lock      *rootLock
+	mu        sync.RWMutex // held for reading by single-package operations and for writing by others
+	pkgLocks  packageLocks
+	build     BuildOptions
+	observers []Observer
+	logger    *slog.Logger
+	recovered []Operation // operations recovered by OpenRoot
+}
+
+var _ io.Closer = (*Root)(nil)
+
+// Option sets up package root opened by CreateRoot or OpenRoot
+type Option func(*Root)
+
+// WithLockTimeout sets how long operations wait for package root locked by another process.
+// Zero timeout (default) means failing with *LockedError immediately, negative one means waiting forever.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(r *Root) {
+		r.lock.timeout = timeout
+	}
 }
 
 // DefaultPath is a default path for root
 const DefaultPath = "/ira/ipkg"
 
 // CreateRoot creates package root on specified path. If directory path not exists, it will be created.
-func CreateRoot(path string) (*Root, error) {
+func CreateRoot(path string, opts ...Option) (*Root, error) {
 	// Checking input parameter
 	err := checkRootPath(path, true)
 	if err != nil {
@@ -30,14 +59,16 @@ func CreateRoot(path string) (*Root, error) {
 	// Creating database
 	db, err := os.Create(filepath.Join(path, "db.sqlite3"))
 	if err != nil {
-		return nil, fmt.Errorf("creating database file: %v", err)
+		return nil, fmt.Errorf("creating database file: %w", err)
 	}
 	db.Close()
 	// Create package root
-	return setupPackageRoot(path)
+	return setupPackageRoot(path, opts)
 }
 
-func OpenRoot(path string) (*Root, error) {
+// OpenRoot opens existing package root and recovers operations interrupted in previous run
+// If root is locked by another process, interrupted operations aren't recovered because they can be still in progress.
+func OpenRoot(path string, opts ...Option) (*Root, error) {
 	// Checking input parameter
 	err := checkRootPath(path, false)
 	if err != nil {
@@ -46,14 +77,39 @@ func OpenRoot(path string) (*Root, error) {
 
 	// Checking is path a package root
 	if _, err := os.Stat(filepath.Join(path, "db.sqlite3")); os.IsNotExist(err) {
-		return nil, fmt.Errorf("directory %s is not a package root", err)
+		return nil, fmt.Errorf("directory %s is not a package root", path)
 	}
 	// Create package root
-	return setupPackageRoot(path)
+	root, err := setupPackageRoot(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Completing operations interrupted in previous run
+	var lockedErr *LockedError
+	root.recovered, err = root.Recover()
+	if errors.As(err, &lockedErr) {
+		root.recovered = nil
+	} else if err != nil {
+		root.Close()
+		return nil, fmt.Errorf("recovering interrupted operations: %w", err)
+	}
+	return root, nil
+}
+
+// Close closes package root waiting for running installations, removals and other changing operations.
+// Read-only methods aren't waited for, so they must not be called concurrently with Close. Root can't be used after closing
+func (r *Root) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.db.Close()
 }
 
 // FindPackage gets package by name and version. If there is no package, returns sql.ErrNoRows
 func (r *Root) FindPackage(name string, version string) (*PkgConfig, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
 	cfg := new(PkgConfig)
 	cfg.Name = name
 	cfg.Version = version
@@ -62,22 +118,42 @@ func (r *Root) FindPackage(name string, version string) (*PkgConfig, error) {
 	if err == sql.ErrNoRows {
 		return nil, err
 	} else if err != nil {
-		return nil, fmt.Errorf("in FindPackage: %v", err)
+		return nil, fmt.Errorf("in FindPackage: %w", err)
 	}
 	cfg.Dependencies = UnserializeDependencies(dependencies)
 	return cfg, nil
 }
 
+// IsActive checks if package name-$version is the active version of package.
+// Package which isn't installed or can't be checked isn't active
 func (r *Root) IsActive(name, version string) bool {
-	if _, err := r.FindPackage(name, version); err == sql.ErrNoRows {
-		return false
+	active, err := r.isActive(name, version)
+	return err == nil && active
+}
+
+// isActive checks if package name-$version is the active version of package.
+// If there is no package, returns sql.ErrNoRows
+func (r *Root) isActive(name, version string) (bool, error) {
+	if err := r.lock.lock(false); err != nil {
+		return false, err
 	}
-	path := filepath.Join(r.path, name+"-$"+version)
-	return !osextra.Exists(filepath.Join(path, ".ira", "deactivated"))
+	defer r.lock.unlock(false)
+	var active bool
+	err := r.db.QueryRow("SELECT active FROM packages WHERE name = ? AND version = ?", name, version).Scan(&active)
+	if err == sql.ErrNoRows {
+		return false, err
+	} else if err != nil {
+		return false, fmt.Errorf("in isActive: %w", err)
+	}
+	return active, nil
 }
 
 // FindPackagesByName returns all packages with the same name
 func (r *Root) FindPackagesByName(name string) ([]PkgConfig, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
 	var result []PkgConfig
 	rows, err := r.db.Query("SELECT version, dependencies FROM packages WHERE name = ?", name)
 	if err != nil {
@@ -101,23 +177,33 @@ func (r *Root) FindPackagesByName(name string) ([]PkgConfig, error) {
 
 // IsDependency checks is package installed by user (false) or as dependency (true).
 func (r *Root) IsDependency(name, version string) (bool, error) {
+	if err := r.lock.lock(false); err != nil {
+		return false, err
+	}
+	defer r.lock.unlock(false)
 	var byUser int
 	err := r.db.QueryRow("SELECT by_user FROM packages WHERE name = ? AND version = ?", name, version).Scan(&byUser)
 	if err == sql.ErrNoRows {
 		return false, err
 	} else if err != nil {
-		return false, fmt.Errorf("in IsDependency: %v", err)
+		return false, fmt.Errorf("in IsDependency: %w", err)
 	}
 	return byUser == 0, nil
 }
 
-// MarkAsUserInstalled tries to mark package as installed by user
+// MarkAsUserInstalled tries to mark package as installed by user.
+// If package is not installed, returns ErrNotInstalled
 func (r *Root) MarkAsUserInstalled(name, version string) error {
+	unlock, err := r.lockPackage(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	isDependency, err := r.IsDependency(name, version)
 	if err == sql.ErrNoRows {
-		return err
+		return fmt.Errorf("package %s-$%s is %w", name, version, ErrNotInstalled)
 	} else if err != nil {
-		return fmt.Errorf("in MarkAsUserInstalled: %v", err)
+		return fmt.Errorf("in MarkAsUserInstalled: %w", err)
 	}
 	if !isDependency {
 		return fmt.Errorf("package %s-$%s isn't a dependency", name, version)
@@ -126,15 +212,66 @@ func (r *Root) MarkAsUserInstalled(name, version string) error {
 	return err
 }
 
+// Packages returns all packages installed in root
+func (r *Root) Packages() ([]PkgConfig, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	var result []PkgConfig
+	rows, err := r.db.Query("SELECT name, version, dependencies FROM packages")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cfg PkgConfig
+		var dependencies string
+		err = rows.Scan(&cfg.Name, &cfg.Version, &dependencies)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Dependencies = UnserializeDependencies(dependencies)
+		result = append(result, cfg)
+	}
+	return result, rows.Err()
+}
+
+// ReverseDependencies returns all installed packages which require package name-$version.
+// Packages having it as optional dependency are not included.
+func (r *Root) ReverseDependencies(name, version string) ([]PkgConfig, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	pkgs, err := r.Packages()
+	if err != nil {
+		return nil, fmt.Errorf("in ReverseDependencies: %w", err)
+	}
+	id := PackageID(name, version)
+	var result []PkgConfig
+	for _, pkg := range pkgs {
+		if pkg.Dependencies[id] {
+			result = append(result, pkg)
+		}
+	}
+	return result, nil
+}
+
+// CanBeRemoved checks that no installed package requires package name-$version
 func (r *Root) CanBeRemoved(name, version string) (bool, error) {
-	var usedBy int
-	err := r.db.QueryRow("SELECT used_by FROM packages WHERE name = ? AND version = ?", name, version).Scan(&usedBy)
-	if err == sql.ErrNoRows {
+	if err := r.lock.lock(false); err != nil {
 		return false, err
-	} else if err != nil {
-		return false, fmt.Errorf("in CanBeRemoved: %v", err)
 	}
-	return usedBy == 0, nil
+	defer r.lock.unlock(false)
+	if _, err := r.FindPackage(name, version); err != nil {
+		return false, err
+	}
+	dependents, err := r.ReverseDependencies(name, version)
+	if err != nil {
+		return false, fmt.Errorf("in CanBeRemoved: %w", err)
+	}
+	return len(dependents) == 0, nil
 }
 
 func checkRootPath(path string, create bool) error {
@@ -155,12 +292,21 @@ func checkRootPath(path string, create bool) error {
 	return nil
 }
 
-func setupPackageRoot(path string) (*Root, error) {
-	pkgroot := &Root{path: path}
+func setupPackageRoot(path string, opts []Option) (*Root, error) {
+	// Absolute path keeps root usable when working directory is changed (e.g. by IScript)
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	pkgroot := &Root{path: path, lock: newRootLock(path, 0), logger: discardLogger}
+	for _, opt := range opts {
+		opt(pkgroot)
+	}
 	// Opening database in a temporary variable
-	db, err := sql.Open("sqlite3", filepath.Join(pkgroot.path, "db.sqlite3"))
+	// Busy timeout makes concurrent connections wait for each other instead of failing
+	db, err := sql.Open("sqlite3", filepath.Join(pkgroot.path, "db.sqlite3")+"?_busy_timeout=5000")
 	if err != nil {
-		return nil, fmt.Errorf("opening database: %v", err)
+		return nil, fmt.Errorf("opening database: %w", err)
 	}
 	// Creating table IF IT NOT EXISTS.
 	// If exists, it won't be truncated
@@ -173,7 +319,18 @@ func setupPackageRoot(path string) (*Root, error) {
 		used_by INTEGER NOT NULL DEFAULT (0)
 	);`)
 	if err != nil {
-		return nil, fmt.Errorf("setup database: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("setup database: %w", err)
+	}
+	// Every version of package can be installed only once
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS packages_name_version ON packages (name, version);")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("setup database: %w", err)
+	}
+	if err = pkgroot.migrate(db); err != nil {
+		db.Close()
+		return nil, err
 	}
 	// Setting database
 	pkgroot.db = db
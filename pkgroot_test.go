@@ -1,6 +1,7 @@
 package ipkg
 
 import (
+	"database/sql"
 	"os"
 	"path/filepath"
 	"testing"
@@ -13,11 +14,12 @@ func TestMakeRoot(t *testing.T) {
 	if err != nil {
 		t.Fatalf("creating package root: %v", err)
 	}
+	defer root.Close()
 	if root == nil {
 		t.Fatal("package root wasn't returned")
 	}
-	if root.path != path {
-		t.Errorf("root has wrong path: got %q, expepected %q", root.path, path)
+	if abs, _ := filepath.Abs(path); root.path != abs {
+		t.Errorf("root has wrong path: got %q, expepected %q", root.path, abs)
 	}
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		t.Fatal("package root wasn't created")
@@ -34,10 +36,86 @@ func TestOpenRoot(t *testing.T) {
 	if err != nil {
 		t.Fatalf("opening package root: %v", err)
 	}
+	defer root.Close()
 	if root == nil {
 		t.Fatal("package root wasn't returned")
 	}
-	if root.path != path {
-		t.Errorf("root has wrong path: got %q, expepected %q", root.path, path)
+	if abs, _ := filepath.Abs(path); root.path != abs {
+		t.Errorf("root has wrong path: got %q, expepected %q", root.path, abs)
+	}
+}
+
+func TestCloseRoot(t *testing.T) {
+	path := t.TempDir()
+	root, err := CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = root.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = root.Packages(); err == nil {
+		t.Error("closed root is still working")
+	}
+	// Opening and closing root must not leak file descriptors
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("counting open files isn't supported:", err)
+	}
+	for i := 0; i < 20; i++ {
+		root, err = OpenRoot(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = root.Packages(); err != nil {
+			t.Fatal(err)
+		}
+		if err = root.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, _ := os.ReadDir("/proc/self/fd")
+	if len(after) > len(fds) {
+		t.Errorf("%d file descriptors leaked", len(after)-len(fds))
+	}
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	path := t.TempDir()
+	// Database created by the first version of ipkg
+	db, err := sql.Open("sqlite3", filepath.Join(path, "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE packages (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		version TEXT NOT NULL,
+		dependencies TEXT NOT NULL,
+		by_user INTEGER NOT NULL DEFAULT (0),
+		used_by INTEGER NOT NULL DEFAULT (0)
+	);
+	INSERT INTO packages VALUES (NULL, 'old', '1.0', '', 1, 0);`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := OpenRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if version, err := databaseVersion(root.db); err != nil || version != len(migrations) {
+		t.Errorf("database wasn't upgraded: version %d (%v)", version, err)
+	}
+	lockfile, err := root.ExportLockfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lockfile.Packages) != 1 || lockfile.Packages[0].Checksum != "" {
+		t.Errorf("wrong packages after upgrade: %+v", lockfile.Packages)
+	}
+	if root.IsActive("old", "1.0") {
+		t.Error("package without installation folder is active")
 	}
 }
@@ -2,6 +2,7 @@ package ipkg
 
 import (
 	"sort"
+	"strings"
 
 	"golang.org/x/mod/semver"
 )
@@ -42,6 +43,62 @@ var (
 	}
 
 	SortByVersion PkgSortMethod = func(first, second *PkgConfig) bool {
-		return semver.Compare(first.Version, second.Version) == -1
+		return compareVersions(first.Version, second.Version) == -1
 	}
 )
+
+// compareVersions compares versions as semver does, but versions don't need "v" prefix ("1.0" is v1.0).
+// Versions which aren't semantic ones are compared with numbers inside them, so "10.0" is newer than "9.0"
+func compareVersions(a, b string) int {
+	a, b = strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")
+	if semver.IsValid("v"+a) && semver.IsValid("v"+b) {
+		if result := semver.Compare("v"+a, "v"+b); result != 0 {
+			return result
+		}
+	}
+	// Equal semantic versions ("1.0" and "1.0.0") are ordered too, so sorting doesn't depend on input order
+	return compareNatural(a, b)
+}
+
+// compareNatural compares strings treating runs of digits as numbers
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da != "" && db != "" {
+			// Leading zeros don't change number
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return compareInts(len(na), len(nb))
+			}
+			if na != nb {
+				return strings.Compare(na, nb)
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return compareInts(int(a[0]), int(b[0]))
+		}
+		a, b = a[1:], b[1:]
+	}
+	return compareInts(len(a), len(b))
+}
+
+// digitPrefix returns digits at the beginning of s
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
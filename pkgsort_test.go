@@ -0,0 +1,31 @@
+package ipkg
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "2.0", -1},
+		{"10.0", "9.0", 1},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.0.0-beta", "1.0.0", -1},
+		{"1.0-beta", "1.0-beta2", -1},
+		{"r10", "r9", 1},
+		{"1.0", "1.0", 0},
+	}
+	for _, test := range tests {
+		if result := compareVersions(test.a, test.b); result != test.expected {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", test.a, test.b, result, test.expected)
+		}
+		if result := compareVersions(test.b, test.a); result != -test.expected {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", test.b, test.a, result, -test.expected)
+		}
+	}
+	pkgs := []PkgConfig{{Version: "9.0"}, {Version: "10.0"}, {Version: "1.0"}}
+	SortByVersion.Sort(pkgs, true)
+	if pkgs[0].Version != "10.0" || pkgs[2].Version != "1.0" {
+		t.Errorf("wrong order: %v", pkgs)
+	}
+}
@@ -0,0 +1,231 @@
+package ipkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	osextra "github.com/ira-package-manager/gobetter/os_extra"
+)
+
+// Every installed package is cached as built package tree in <root>/cache/name-$version,
+// so Repair can install its files again without package file
+
+// cacheDir returns path to cached package name-$version
+func (r *Root) cacheDir(name, version string) string {
+	return filepath.Join(r.path, "cache", PackageID(name, version))
+}
+
+// cachePackage copies built package from workPath to cache
+func (r *Root) cachePackage(name, version, workPath string) error {
+	dir := r.cacheDir(name, version)
+	// Package is copied into temporary folder first, so cache is never left halfway
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		return fmt.Errorf("creating cache folder: %w", err)
+	}
+	if err := osextra.CopyDirectory(workPath, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("caching package: %w", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("caching package: %w", err)
+	}
+	return nil
+}
+
+// removeCache removes cached package name-$version
+func (r *Root) removeCache(name, version string) error {
+	if err := os.RemoveAll(r.cacheDir(name, version)); err != nil {
+		return fmt.Errorf("removing cached package: %v", err)
+	}
+	return nil
+}
+
+// RepairReport describes what Repair has done
+type RepairReport struct {
+	Repaired  []Issue       `json:"repaired"`  // problems fixed by repair
+	Preserved []Issue       `json:"preserved"` // modified configuration files which were kept
+	Remaining *VerifyReport `json:"remaining"` // result of verification after repair
+}
+
+// Repair restores missing and modified files of package name-$version from cache, fixes their permissions
+// and re-creates activation links if package is active. Modified configuration files and extra files are kept.
+// Packages installed without cache (e.g. by older ipkg) can't be repaired, they must be reinstalled
+func (r *Root) Repair(ctx context.Context, name, version string) (*RepairReport, error) {
+	unlock, err := r.lockPackage(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return r.repair(ctx, name, version)
+}
+
+// hasCache checks if package name-$version is cached, so it can be repaired
+func (r *Root) hasCache(name, version string) bool {
+	return osextra.Exists(filepath.Join(r.cacheDir(name, version), ".ira", "iscript"))
+}
+
+func (r *Root) repair(ctx context.Context, name, version string) (*RepairReport, error) {
+	verified, err := r.Verify(name, version)
+	if err != nil {
+		return nil, err
+	}
+	report := &RepairReport{Repaired: []Issue{}, Preserved: []Issue{}, Remaining: verified}
+	if verified.Clean() {
+		return report, nil
+	}
+	logger := r.pkgLogger(name, version)
+	cache := r.cacheDir(name, version)
+	if !r.hasCache(name, version) {
+		return nil, fmt.Errorf("package %s-$%s has no cached copy, it must be reinstalled", name, version)
+	}
+	installDir, err := filepath.Abs(filepath.Join(r.path, PackageID(name, version)))
+	if err != nil {
+		return nil, err
+	}
+	files, err := r.Files(name, version)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[string]OwnedFile)
+	for _, file := range files {
+		recorded[file.Path] = file
+	}
+
+	// Package is installed again into temporary folder, which is a source of restored files
+	var fresh string
+	relink := false
+	for _, issue := range verified.Issues {
+		switch issue.Problem {
+		case ProblemBrokenLink:
+			relink = true
+			continue
+		case ProblemExtra:
+			continue
+		case ProblemConfigEdited:
+			report.Preserved = append(report.Preserved, issue)
+			continue
+		}
+		if fresh == "" && issue.Problem != ProblemMode {
+			if fresh, err = r.reinstallCached(ctx, cache, name, version); err != nil {
+				return nil, err
+			}
+			defer os.RemoveAll(fresh)
+		}
+		rel, err := filepath.Rel(installDir, issue.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err = restoreFile(filepath.Join(fresh, rel), recorded[issue.Path], issue.Problem); err != nil {
+			return nil, fmt.Errorf("restoring %s: %w", issue.Path, err)
+		}
+		report.Repaired = append(report.Repaired, issue)
+	}
+	if err = r.restoreMetadata(cache, installDir); err != nil {
+		return nil, err
+	}
+	if relink && r.IsActive(name, version) {
+		if err = r.activate(name, version); err != nil {
+			return nil, fmt.Errorf("re-creating activation links: %w", err)
+		}
+		for _, issue := range verified.Issues {
+			if issue.Problem == ProblemBrokenLink {
+				report.Repaired = append(report.Repaired, issue)
+			}
+		}
+	}
+	if report.Remaining, err = r.Verify(name, version); err != nil {
+		return nil, err
+	}
+	logger.Info("package repaired", "repaired", len(report.Repaired), "preserved", len(report.Preserved), "remaining", len(report.Remaining.Issues))
+	return report, nil
+}
+
+// reinstallCached runs IScript of cached package into temporary folder inside root and returns path to it
+func (r *Root) reinstallCached(ctx context.Context, cache, name, version string) (string, error) {
+	script, err := os.ReadFile(filepath.Join(cache, ".ira", "iscript"))
+	if err != nil {
+		return "", fmt.Errorf("reading cached IScript: %w", err)
+	}
+	script, _, err = extractActivation(script)
+	if err != nil {
+		return "", fmt.Errorf("parsing iscript: %w", err)
+	}
+	fresh, err := os.MkdirTemp(r.path, ".repair-"+PackageID(name, version)+"-")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary folder: %w", err)
+	}
+	if err = runInstallScript(ctx, script, cache, fresh); err != nil {
+		os.RemoveAll(fresh)
+		return "", err
+	}
+	return fresh, nil
+}
+
+// restoreFile replaces file with its copy from source and sets recorded permissions.
+// If only permissions were changed, source isn't used
+func restoreFile(source string, file OwnedFile, problem Problem) error {
+	if problem == ProblemMode {
+		return os.Chmod(file.Path, file.Mode)
+	}
+	if info, err := os.Lstat(file.Path); err == nil {
+		// Directories are kept with their content, other files are replaced
+		if file.Type == FileDirectory && info.IsDir() {
+			return os.Chmod(file.Path, file.Mode)
+		}
+		if err = os.RemoveAll(file.Path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(file.Path), os.ModePerm); err != nil {
+		return err
+	}
+	switch file.Type {
+	case FileDirectory:
+		if err := os.Mkdir(file.Path, file.Mode); err != nil {
+			return err
+		}
+	case FileSymlink:
+		return osextra.CopySymLink(source, file.Path)
+	default:
+		if err := osextra.Copy(source, file.Path); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(file.Path, file.Mode)
+}
+
+// restoreMetadata restores IScript and activation log of package from cache if they were removed
+func (r *Root) restoreMetadata(cache, installDir string) error {
+	if err := os.MkdirAll(filepath.Join(installDir, ".ira"), os.ModePerm); err != nil {
+		return err
+	}
+	scriptPath := filepath.Join(installDir, ".ira", "iscript")
+	if !osextra.Exists(scriptPath) {
+		if err := osextra.Copy(filepath.Join(cache, ".ira", "iscript"), scriptPath); err != nil {
+			return fmt.Errorf("restoring IScript: %w", err)
+		}
+	}
+	if osextra.Exists(activationLog(installDir)) {
+		return nil
+	}
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return fmt.Errorf("reading IScript: %w", err)
+	}
+	_, links, err := extractActivation(script)
+	if err != nil {
+		return fmt.Errorf("parsing iscript: %w", err)
+	}
+	return writeActivationLog(installDir, links)
+}
@@ -0,0 +1,90 @@
+package ipkg_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestRepair(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	pkg := writeActivatedPackage(t, dir, links, "1.0", "tool", "lib", "data", "main.ini")
+	writeConfigFiles(t, pkg, "1.0", "main.ini")
+	if err = root.InstallPackage(pkg, false); err != nil {
+		t.Fatal(err)
+	}
+	// Package is repaired from cache, not from its source
+	if err = os.RemoveAll(pkg); err != nil {
+		t.Fatal(err)
+	}
+
+	installDir := filepath.Join(path, ipkg.PackageID("tool", "1.0"))
+	changes := []error{
+		os.Remove(filepath.Join(installDir, "tool")),
+		os.WriteFile(filepath.Join(installDir, "lib"), []byte("changed"), 0644),
+		os.Chmod(filepath.Join(installDir, "data"), 0600),
+		os.WriteFile(filepath.Join(installDir, "main.ini"), []byte("edited"), 0644),
+		os.WriteFile(filepath.Join(installDir, "extra"), nil, 0644),
+		os.Remove(filepath.Join(links, "lib")),
+	}
+	for _, err := range changes {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := root.Repair(context.Background(), "tool", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Preserved) != 1 || report.Preserved[0].Path != filepath.Join(installDir, "main.ini") {
+		t.Errorf("wrong preserved files: %+v", report.Preserved)
+	}
+	remaining := map[string]ipkg.Problem{}
+	for _, issue := range report.Remaining.Issues {
+		remaining[issue.Path] = issue.Problem
+	}
+	expected := map[string]ipkg.Problem{
+		filepath.Join(installDir, "main.ini"): ipkg.ProblemConfigEdited,
+		filepath.Join(installDir, "extra"):    ipkg.ProblemExtra,
+	}
+	if len(remaining) != len(expected) {
+		t.Errorf("wrong problems after repair: %+v", report.Remaining.Issues)
+	}
+	for file, problem := range expected {
+		if remaining[file] != problem {
+			t.Errorf("wrong problem of %s after repair: got %q, expected %s", file, remaining[file], problem)
+		}
+	}
+	for _, file := range []string{"tool", "lib"} {
+		content, err := os.ReadFile(filepath.Join(links, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "1.0" {
+			t.Errorf("%s isn't restored: %q", file, content)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(installDir, "main.ini")); string(content) != "edited" {
+		t.Errorf("configuration file is overwritten: %q", content)
+	}
+
+	if err = root.RemovePackage("tool", "1.0", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(path, "cache", ipkg.PackageID("tool", "1.0"))); !os.IsNotExist(err) {
+		t.Errorf("cache of removed package is kept: %v", err)
+	}
+}
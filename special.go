@@ -2,6 +2,7 @@ package ipkg
 
 import (
 	"archive/zip"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -9,36 +10,49 @@ import (
 	"strings"
 )
 
-func unzipPackage(path string) (string, error) {
-	// Getting paths used for unzipping
-	tempDir := filepath.Join(os.TempDir(), "ira", "ipkg", "install")
-	archivePath, err := prepareCompressedPackage(path, tempDir)
-	if err != nil {
-		return "", err
-	}
-
+// unzipPackage extracts IPKG archive into new temporary directory and returns path to it.
+// Caller must remove this directory after working with package
+// Cancelling ctx stops extracting, extracted files are removed then.
+// progress is called with number of extracted bytes and total size of files in archive
+func unzipPackage(ctx context.Context, path string, progress func(done, total int64)) (string, error) {
 	// Opening archive
-	archive, err := zip.OpenReader(archivePath)
+	archive, err := zip.OpenReader(path)
 	if err != nil {
 		return "", fmt.Errorf("opening %s as archive: %v", path, err)
 	}
 	defer archive.Close()
-	archivePath, err = filepath.Abs(archivePath) // needed in security purposes
+	destination, err := os.MkdirTemp("", "ipkg-"+strings.TrimSuffix(filepath.Base(path), ".ipkg")+"-")
 	if err != nil {
+		return "", fmt.Errorf("making temporary dir: %v", err)
+	}
+	destination, err = filepath.Abs(destination) // needed in security purposes
+	if err != nil {
+		os.RemoveAll(destination)
 		return "", err
 	}
-	destination := strings.TrimSuffix(archivePath, ".zip")
 	// Unzipping archive
+	var done, total int64
+	for _, f := range archive.File {
+		total += int64(f.UncompressedSize64)
+	}
+	onRead := func(n int) {
+		done += int64(n)
+		progress(done, total)
+	}
 	for _, f := range archive.File {
-		err := unzipFile(f, destination)
+		err := unzipFile(ctx, f, destination, onRead)
 		if err != nil {
+			os.RemoveAll(destination)
 			return "", err
 		}
 	}
 	return destination, nil
 }
 
-func unzipFile(f *zip.File, destination string) error {
+func unzipFile(ctx context.Context, f *zip.File, destination string, onRead func(int)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	filePath := filepath.Join(destination, f.Name)
 	// For security purposes
 	if !strings.HasPrefix(filePath, filepath.Clean(destination)+string(os.PathSeparator)) {
@@ -69,9 +83,27 @@ func unzipFile(f *zip.File, destination string) error {
 	defer zippedFile.Close()
 
 	// Unzipping file
-	if _, err := io.Copy(destinationFile, zippedFile); err != nil {
+	if _, err := io.Copy(destinationFile, &contextReader{ctx: ctx, r: zippedFile, onRead: onRead}); err != nil {
 		return err
 	}
 	return nil
 
 }
+
+// contextReader stops reading when context is cancelled and reports number of read bytes
+type contextReader struct {
+	ctx    context.Context
+	r      io.Reader
+	onRead func(int)
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.onRead(n)
+	}
+	return n, err
+}
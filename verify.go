@@ -0,0 +1,188 @@
+package ipkg
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Problem is a kind of difference between recorded and installed files
+type Problem string
+
+// Problems found by Verify
+const (
+	ProblemMissing    Problem = "missing"      // recorded file doesn't exist
+	ProblemModified   Problem = "modified"     // content, link target or type of file was changed
+	ProblemMode       Problem = "mode-changed" // permissions of file were changed
+	ProblemExtra      Problem = "extra"        // file in installation folder wasn't installed by package
+	ProblemBrokenLink Problem = "broken-link"  // activation link of active package is missing or leads nowhere
+	// ProblemConfigEdited means configuration file was edited by user. It's expected, so it isn't a drift
+	ProblemConfigEdited Problem = "config-edited"
+)
+
+// Issue is a difference between recorded and installed file
+type Issue struct {
+	Path    string  `json:"path"`
+	Problem Problem `json:"problem"`
+	Detail  string  `json:"detail,omitempty"`
+}
+
+// VerifyReport describes differences between files recorded when package was installed and files on disk
+type VerifyReport struct {
+	Name    string  `json:"name"`
+	Version string  `json:"version"`
+	Issues  []Issue `json:"issues"` // sorted by path
+}
+
+// Clean returns true if no differences were found
+func (report *VerifyReport) Clean() bool {
+	return len(report.Issues) == 0
+}
+
+// Drift returns true if installed files were changed not only by editing configuration files
+func (report *VerifyReport) Drift() bool {
+	for _, issue := range report.Issues {
+		if issue.Problem != ProblemConfigEdited {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks files of package name-$version against hashes and modes recorded when it was installed.
+// Activation links are checked only if package is active
+func (r *Root) Verify(name, version string) (*VerifyReport, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	active, err := r.isActive(name, version)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("package %s-$%s is %w", name, version, ErrNotInstalled)
+	} else if err != nil {
+		return nil, fmt.Errorf("verifying %s-$%s: %w", name, version, err)
+	}
+	files, err := r.Files(name, version)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{Name: name, Version: version, Issues: []Issue{}}
+	recorded := make(map[string]bool)
+	for _, file := range files {
+		recorded[file.Path] = true
+		if file.Config {
+			recorded[file.Path+NewConfigSuffix] = true // new configuration file which wasn't applied
+		}
+		if file.Type == FileActivation {
+			if active {
+				if issue := r.verifyLink(name, file.Path); issue != nil {
+					report.Issues = append(report.Issues, *issue)
+				}
+			}
+			continue
+		}
+		if issue := verifyFile(file); issue != nil {
+			report.Issues = append(report.Issues, *issue)
+		}
+	}
+	// Looking for files which weren't installed by package
+	installDir, err := filepath.Abs(filepath.Join(r.path, PackageID(name, version)))
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(installDir, func(path string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == installDir {
+			return filepath.SkipDir // reported as missing files
+		} else if err != nil {
+			return err
+		}
+		if path == installDir {
+			return nil
+		}
+		if entry.IsDir() && entry.Name() == ".ira" && filepath.Dir(path) == installDir {
+			return filepath.SkipDir
+		}
+		if !recorded[path] {
+			report.Issues = append(report.Issues, Issue{Path: path, Problem: ProblemExtra})
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("verifying %s-$%s: %w", name, version, err)
+	}
+	sort.Slice(report.Issues, func(i, j int) bool { return report.Issues[i].Path < report.Issues[j].Path })
+	return report, nil
+}
+
+// VerifyAll verifies all installed packages. Reports are sorted by package IDs
+func (r *Root) VerifyAll() ([]*VerifyReport, error) {
+	if err := r.lock.lock(false); err != nil {
+		return nil, err
+	}
+	defer r.lock.unlock(false)
+	pkgs, err := r.Packages()
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*VerifyReport, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		report, err := r.Verify(pkg.Name, pkg.Version)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return PackageID(reports[i].Name, reports[i].Version) < PackageID(reports[j].Name, reports[j].Version)
+	})
+	return reports, nil
+}
+
+// verifyFile compares recorded file with file on disk
+func verifyFile(file OwnedFile) *Issue {
+	actual, err := describeFile(file.Path)
+	if os.IsNotExist(err) {
+		return &Issue{Path: file.Path, Problem: ProblemMissing}
+	} else if err != nil {
+		return &Issue{Path: file.Path, Problem: ProblemModified, Detail: err.Error()}
+	}
+	switch {
+	case actual.Type != file.Type:
+		return &Issue{Path: file.Path, Problem: ProblemModified, Detail: fmt.Sprintf("%s became %s", file.Type, actual.Type)}
+	case actual.SHA256 != file.SHA256 && file.Config:
+		return &Issue{Path: file.Path, Problem: ProblemConfigEdited}
+	case actual.SHA256 != file.SHA256:
+		return &Issue{Path: file.Path, Problem: ProblemModified, Detail: "checksum mismatch"}
+	case actual.Type != FileSymlink && actual.Mode != file.Mode:
+		return &Issue{Path: file.Path, Problem: ProblemMode, Detail: fmt.Sprintf("%v, expected %v", actual.Mode, file.Mode)}
+	}
+	return nil
+}
+
+// verifyLink checks that activation link of package name points to current/<name> and leads to existing file
+func (r *Root) verifyLink(name, path string) *Issue {
+	target, err := os.Readlink(path)
+	if os.IsNotExist(err) {
+		return &Issue{Path: path, Problem: ProblemBrokenLink, Detail: "link is missing"}
+	} else if err != nil {
+		return &Issue{Path: path, Problem: ProblemBrokenLink, Detail: "not a symbolic link"}
+	}
+	current, err := filepath.Abs(r.currentLink(name))
+	if err != nil {
+		return &Issue{Path: path, Problem: ProblemBrokenLink, Detail: err.Error()}
+	}
+	if !strings.HasPrefix(target, current+string(filepath.Separator)) && !r.isLegacyTarget(name, target) {
+		return &Issue{Path: path, Problem: ProblemBrokenLink, Detail: "link points to " + target}
+	}
+	if _, err = os.Stat(path); err != nil {
+		return &Issue{Path: path, Problem: ProblemBrokenLink, Detail: "target " + target + " doesn't exist"}
+	}
+	return nil
+}
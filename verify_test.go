@@ -0,0 +1,90 @@
+package ipkg_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ira-package-manager/ipkg"
+)
+
+func TestVerify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	path := t.TempDir()
+	root, err := ipkg.CreateRoot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	dir, links := t.TempDir(), t.TempDir()
+	if err = root.InstallPackage(writeActivatedPackage(t, dir, links, "1.0", "tool", "lib", "data", "doc"), false); err != nil {
+		t.Fatal(err)
+	}
+	report, err := root.Verify("tool", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Clean() {
+		t.Fatalf("freshly installed package has problems: %+v", report.Issues)
+	}
+
+	installDir := filepath.Join(path, ipkg.PackageID("tool", "1.0"))
+	changes := []error{
+		os.Remove(filepath.Join(installDir, "tool")),
+		os.WriteFile(filepath.Join(installDir, "lib"), []byte("changed"), 0644),
+		os.Chmod(filepath.Join(installDir, "data"), 0600),
+		os.WriteFile(filepath.Join(installDir, "extra"), nil, 0644),
+		os.Remove(filepath.Join(links, "doc")),
+	}
+	for _, err := range changes {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err = root.Verify("tool", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]ipkg.Problem{
+		filepath.Join(installDir, "tool"):  ipkg.ProblemMissing,
+		filepath.Join(installDir, "lib"):   ipkg.ProblemModified,
+		filepath.Join(installDir, "data"):  ipkg.ProblemMode,
+		filepath.Join(installDir, "extra"): ipkg.ProblemExtra,
+		filepath.Join(links, "doc"):        ipkg.ProblemBrokenLink,
+		filepath.Join(links, "tool"):       ipkg.ProblemBrokenLink, // leads to removed file
+	}
+	if len(report.Issues) != len(expected) {
+		t.Errorf("expected %d problems, got %+v", len(expected), report.Issues)
+	}
+	for _, issue := range report.Issues {
+		if expected[issue.Path] != issue.Problem {
+			t.Errorf("wrong problem of %s: got %s, expected %s", issue.Path, issue.Problem, expected[issue.Path])
+		}
+	}
+	reports, err := root.VerifyAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Clean() {
+		t.Errorf("wrong reports of all packages: %+v", reports)
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	root, err := ipkg.CreateRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = root.Verify("missing", "1.0"); !errors.Is(err, ipkg.ErrNotInstalled) {
+		t.Errorf("expected ErrNotInstalled, got %v", err)
+	}
+	// Failed query isn't reported as missing package
+	root.Close()
+	if _, err = root.Verify("missing", "1.0"); err == nil || errors.Is(err, ipkg.ErrNotInstalled) {
+		t.Errorf("expected database error, got %v", err)
+	}
+}